@@ -21,7 +21,6 @@ require (
 	github.com/hashicorp/go-plugin v1.4.10
 	github.com/sirupsen/logrus v1.9.0
 	github.com/solo-io/solo-apis v0.0.0-20230714165959-0247436e773d
-
 )
 
 require (
@@ -30,6 +29,7 @@ require (
 	github.com/stretchr/testify v1.8.2
 	golang.org/x/exp v0.0.0-20220921164117-439092de6870
 	google.golang.org/protobuf v1.30.0
+	k8s.io/apimachinery v0.25.8
 	k8s.io/client-go v0.25.8
 	sigs.k8s.io/controller-runtime v0.13.1
 )
@@ -40,6 +40,7 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/emicklei/go-restful/v3 v3.10.1 // indirect
 	github.com/envoyproxy/protoc-gen-validate v0.9.1 // indirect
+	github.com/evanphx/json-patch v5.6.0+incompatible // indirect
 	github.com/evanphx/json-patch/v5 v5.6.0 // indirect
 	github.com/fatih/color v1.13.0 // indirect
 	github.com/fsnotify/fsnotify v1.5.4 // indirect
@@ -97,7 +98,6 @@ require (
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	k8s.io/api v0.25.8 // indirect
-	k8s.io/apimachinery v0.25.8 // indirect
 	k8s.io/component-base v0.25.8 // indirect
 	k8s.io/klog/v2 v2.80.1 // indirect
 	k8s.io/kube-openapi v0.0.0-20221012153701-172d655c2280 // indirect
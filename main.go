@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/plugin"
 
 	rolloutsPlugin "github.com/argoproj/argo-rollouts/rollout/trafficrouting/plugin/rpc"
@@ -23,7 +25,8 @@ func main() {
 	log.SetLevel(log.DebugLevel)
 
 	rpcPluginImp := &plugin.RpcPlugin{
-		LogCtx: logCtx,
+		LogCtx:           logCtx,
+		UseInformerCache: os.Getenv("GLOOEDGE_PLUGIN_INFORMER_CACHE") == "true",
 	}
 
 	var pluginMap = map[string]goPlugin.Plugin{
@@ -0,0 +1,76 @@
+package gloo
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/util"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// cacheSyncTimeout is how long NewCachedGlooV1ClientSet waits for the informer cache to sync
+var cacheSyncTimeout = 30 * time.Second
+
+// NewCachedGlooV1ClientSet returns a clientset like NewGlooV1ClientSet that also serves reads of VirtualServices
+// and RouteTables from a shared informer cache. The informers run until ctx is done, the clientset is returned
+// once they're synced or cacheSyncTimeout passes. Reads are served by the API server until the cache is synced.
+func NewCachedGlooV1ClientSet(ctx context.Context) (GlooV1ClientSet, error) {
+	clientset, err := NewGlooV1ClientSet()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := util.GetKubeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	if err = gwv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	informerCache, err := cache.New(cfg, cache.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	// informers are created on the first read otherwise
+	for _, obj := range []client.Object{&gwv1.VirtualService{}, &gwv1.RouteTable{}} {
+		if _, err = informerCache.GetInformer(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+
+	go func() {
+		// Start blocks until ctx is done
+		_ = informerCache.Start(ctx)
+	}()
+	var synced atomic.Bool
+	syncDone := make(chan struct{})
+	go func() {
+		defer close(syncDone)
+		// WaitForCacheSync blocks until the cache is synced or ctx is done
+		synced.Store(informerCache.WaitForCacheSync(ctx))
+	}()
+	select {
+	case <-syncDone:
+	case <-time.After(cacheSyncTimeout):
+	}
+
+	c := clientset.(*glooV1ClientSet)
+	cachedClient, err := client.NewDelegatingClient(client.NewDelegatingClientInput{
+		CacheReader: informerCache,
+		Client:      c.unstructured,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cached := NewCachedGlooV1ClientSetFromClients(c.gateway, c.gloo, c.fed, c.unstructured, gwv1.NewClientset(cachedClient))
+	cached.(*glooV1ClientSet).cacheSynced = synced.Load
+	return cached, nil
+}
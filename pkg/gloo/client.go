@@ -2,24 +2,138 @@ package gloo
 
 import (
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/util"
-	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	fedgwv1 "github.com/solo-io/solo-apis/pkg/api/fed.gateway.solo.io/v1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type GlooV1ClientSet interface {
-	RouteTables() gloov1.RouteTableClient
-	VirtualServices() gloov1.VirtualServiceClient
+	RouteTables() gwv1.RouteTableClient
+	VirtualServices() gwv1.VirtualServiceClient
+	Gateways() gwv1.GatewayClient
+	Upstreams() gloov1.UpstreamClient
+	FederatedVirtualServices() fedgwv1.FederatedVirtualServiceClient
+	FederatedRouteTables() fedgwv1.FederatedRouteTableClient
+	// Unstructured returns a client for resources without typed clients, e.g. Gloo Platform RouteTables
+	Unstructured() client.Client
+	// CachedRouteTables returns a reader serving RouteTables from the informer cache, or nil when the cache
+	// isn't used or isn't synced yet
+	CachedRouteTables() gwv1.RouteTableReader
+	// CachedVirtualServices returns a reader serving VirtualServices from the informer cache, or nil when the
+	// cache isn't used or isn't synced yet
+	CachedVirtualServices() gwv1.VirtualServiceReader
 }
 
+// glooV1ClientSet combines gateway.solo.io, gloo.solo.io and fed.gateway.solo.io clientsets
+type glooV1ClientSet struct {
+	gateway      gwv1.Clientset
+	gloo         gloov1.Clientset
+	fed          fedgwv1.Clientset
+	unstructured client.Client
+	// cached serves reads of VirtualServices and RouteTables from the informer cache when it's used
+	cached gwv1.Clientset
+	// cacheSynced returns true once the informer cache is synced, the cache is considered synced when it's nil
+	cacheSynced func() bool
+}
+
+// NewGlooV1ClientSet returns a clientset for the cluster of the kube config. API calls made with contexts returned
+// by ContextWithTimeouts time out.
 func NewGlooV1ClientSet() (GlooV1ClientSet, error) {
 	cfg, err := util.GetKubeConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	clientset, err := gloov1.NewClientsetFromConfig(cfg)
+	s := scheme.Scheme
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		gwv1.SchemeBuilder.AddToScheme,
+		gloov1.SchemeBuilder.AddToScheme,
+		fedgwv1.SchemeBuilder.AddToScheme,
+	} {
+		if err = addToScheme(s); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := client.New(cfg, client.Options{Scheme: s})
 	if err != nil {
 		return nil, err
 	}
+	c = NewTimeoutClient(c)
+
+	return NewGlooV1ClientSetFromClients(gwv1.NewClientset(c), gloov1.NewClientset(c), fedgwv1.NewClientset(c), c), nil
+}
+
+func NewGlooV1ClientSetFromClientsets(gateway gwv1.Clientset, gloo gloov1.Clientset) GlooV1ClientSet {
+	return &glooV1ClientSet{gateway: gateway, gloo: gloo}
+}
+
+func NewGlooV1ClientSetFromClients(
+	gateway gwv1.Clientset,
+	gloo gloov1.Clientset,
+	fed fedgwv1.Clientset,
+	unstructured client.Client) GlooV1ClientSet {
+
+	return &glooV1ClientSet{gateway: gateway, gloo: gloo, fed: fed, unstructured: unstructured}
+}
+
+// NewCachedGlooV1ClientSetFromClients returns a clientset like NewGlooV1ClientSetFromClients that reads
+// VirtualServices and RouteTables with the cached clientset
+func NewCachedGlooV1ClientSetFromClients(
+	gateway gwv1.Clientset,
+	gloo gloov1.Clientset,
+	fed fedgwv1.Clientset,
+	unstructured client.Client,
+	cached gwv1.Clientset) GlooV1ClientSet {
+
+	return &glooV1ClientSet{gateway: gateway, gloo: gloo, fed: fed, unstructured: unstructured, cached: cached}
+}
+
+func (c *glooV1ClientSet) RouteTables() gwv1.RouteTableClient {
+	return c.gateway.RouteTables()
+}
+
+func (c *glooV1ClientSet) VirtualServices() gwv1.VirtualServiceClient {
+	return c.gateway.VirtualServices()
+}
+
+func (c *glooV1ClientSet) Gateways() gwv1.GatewayClient {
+	return c.gateway.Gateways()
+}
+
+func (c *glooV1ClientSet) Upstreams() gloov1.UpstreamClient {
+	return c.gloo.Upstreams()
+}
+
+func (c *glooV1ClientSet) FederatedVirtualServices() fedgwv1.FederatedVirtualServiceClient {
+	return c.fed.FederatedVirtualServices()
+}
+
+func (c *glooV1ClientSet) FederatedRouteTables() fedgwv1.FederatedRouteTableClient {
+	return c.fed.FederatedRouteTables()
+}
+
+func (c *glooV1ClientSet) Unstructured() client.Client {
+	return c.unstructured
+}
+
+func (c *glooV1ClientSet) CachedRouteTables() gwv1.RouteTableReader {
+	if !c.useCache() {
+		return nil
+	}
+	return c.cached.RouteTables()
+}
+
+func (c *glooV1ClientSet) CachedVirtualServices() gwv1.VirtualServiceReader {
+	if !c.useCache() {
+		return nil
+	}
+	return c.cached.VirtualServices()
+}
 
-	return clientset, nil
+func (c *glooV1ClientSet) useCache() bool {
+	return c.cached != nil && (c.cacheSynced == nil || c.cacheSynced())
 }
@@ -0,0 +1,37 @@
+package gloo
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	gwmocks "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+)
+
+func Test_glooV1ClientSet_ReadsFromAPIServerUntilCacheIsSynced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cached := gwmocks.NewMockClientset(ctrl)
+	rts := gwmocks.NewMockRouteTableClient(ctrl)
+	vss := gwmocks.NewMockVirtualServiceClient(ctrl)
+	cached.EXPECT().RouteTables().Return(rts)
+	cached.EXPECT().VirtualServices().Return(vss)
+	clientset := NewCachedGlooV1ClientSetFromClients(nil, nil, nil, nil, cached)
+	synced := false
+	clientset.(*glooV1ClientSet).cacheSynced = func() bool { return synced }
+
+	assert.Nil(t, clientset.CachedRouteTables())
+	assert.Nil(t, clientset.CachedVirtualServices())
+
+	synced = true
+
+	assert.Equal(t, rts, clientset.CachedRouteTables())
+	assert.Equal(t, vss, clientset.CachedVirtualServices())
+}
+
+func Test_glooV1ClientSet_DoesNotUseCacheByDefault(t *testing.T) {
+	clientset := NewGlooV1ClientSetFromClients(nil, nil, nil, nil)
+
+	assert.Nil(t, clientset.CachedRouteTables())
+	assert.Nil(t, clientset.CachedVirtualServices())
+}
@@ -0,0 +1,71 @@
+package gloo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// transientBackoff is used to retry API calls failed with transient errors
+var transientBackoff = wait.Backoff{
+	Duration: 50 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    6,
+	Cap:      2 * time.Second,
+}
+
+// isTransient returns true for errors that are likely to go away when the call is retried: server errors,
+// throttling and broken connections. Errors of invalid requests, e.g. validation errors or missing resources,
+// aren't transient.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if k8serrors.IsTooManyRequests(err) ||
+		k8serrors.IsServerTimeout(err) ||
+		k8serrors.IsTimeout(err) ||
+		k8serrors.IsServiceUnavailable(err) ||
+		k8serrors.IsInternalError(err) ||
+		k8serrors.IsUnexpectedServerError(err) {
+		return true
+	}
+	var status k8serrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Code >= 500
+	}
+	return utilnet.IsConnectionReset(err) || utilnet.IsConnectionRefused(err) || utilnet.IsProbableEOF(err)
+}
+
+// retryTransient runs call again with jittered exponential backoff while it fails with transient errors, as long
+// as ctx isn't done. The number of transient errors is added to the error when retries are exhausted.
+func retryTransient(ctx context.Context, call func(ctx context.Context) error) error {
+	backoff := transientBackoff
+	attempts := 0
+	for {
+		err := call(ctx)
+		attempts++
+		if !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		if backoff.Steps <= 1 {
+			return fmt.Errorf("giving up after %d transient errors: %w", attempts, err)
+		}
+
+		delay := backoff.Step()
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			// the call budget would be exceeded while waiting
+			return fmt.Errorf("giving up after %d transient errors: %w", attempts, err)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
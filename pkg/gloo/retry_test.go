@@ -0,0 +1,128 @@
+package gloo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// withTransientBackoff replaces transientBackoff for the duration of the test
+func withTransientBackoff(t *testing.T, backoff wait.Backoff) {
+	original := transientBackoff
+	transientBackoff = backoff
+	t.Cleanup(func() {
+		transientBackoff = original
+	})
+}
+
+// failingCall fails with the errors before succeeding
+type failingCall struct {
+	errs  []error
+	calls int
+}
+
+func (c *failingCall) call(context.Context) error {
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return c.errs[c.calls-1]
+	}
+	return nil
+}
+
+func Test_isTransient(t *testing.T) {
+	gr := schema.GroupResource{Group: "gateway.solo.io", Resource: "virtualservices"}
+	for name, tc := range map[string]struct {
+		err      error
+		expected bool
+	}{
+		"no error":            {err: nil},
+		"too many requests":   {err: k8serrors.NewTooManyRequests("throttled", 1), expected: true},
+		"server timeout":      {err: k8serrors.NewServerTimeout(gr, "patch", 1), expected: true},
+		"timeout":             {err: k8serrors.NewTimeoutError("request timed out", 1), expected: true},
+		"service unavailable": {err: k8serrors.NewServiceUnavailable("etcd leader changed"), expected: true},
+		"internal error":      {err: k8serrors.NewInternalError(errors.New("database is locked")), expected: true},
+		"server error":        {err: k8serrors.NewGenericServerResponse(502, "PATCH", gr, "testvs", "", 0, false), expected: true},
+		"wrapped server error": {
+			err:      fmt.Errorf("VirtualService testns/testvs: %w", k8serrors.NewServiceUnavailable("etcd leader changed")),
+			expected: true,
+		},
+		"connection reset":   {err: syscall.ECONNRESET, expected: true},
+		"connection refused": {err: syscall.ECONNREFUSED, expected: true},
+		"unexpected EOF":     {err: io.ErrUnexpectedEOF, expected: true},
+		"not found":          {err: k8serrors.NewNotFound(gr, "testvs")},
+		"already exists":     {err: k8serrors.NewAlreadyExists(gr, "testvs")},
+		"conflict":           {err: k8serrors.NewConflict(gr, "testvs", errors.New("the object has been modified"))},
+		"invalid":            {err: k8serrors.NewInvalid(schema.GroupKind{Group: "gateway.solo.io", Kind: "VirtualService"}, "testvs", nil)},
+		"other error":        {err: errors.New("admission webhook denied the request")},
+		"deadline exceeded":  {err: context.DeadlineExceeded},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isTransient(tc.err))
+		})
+	}
+}
+
+func Test_retryTransient_RetriesTransientErrors(t *testing.T) {
+	withTransientBackoff(t, wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 6})
+	call := &failingCall{errs: []error{
+		k8serrors.NewServiceUnavailable("etcd leader changed"),
+		k8serrors.NewTooManyRequests("throttled", 0),
+		syscall.ECONNRESET,
+	}}
+
+	err := retryTransient(context.TODO(), call.call)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, call.calls)
+}
+
+func Test_retryTransient_DoesNotRetryOtherErrors(t *testing.T) {
+	withTransientBackoff(t, wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 6})
+	invalid := k8serrors.NewInvalid(schema.GroupKind{Group: "gateway.networking.k8s.io", Kind: "HTTPRoute"}, "echo", nil)
+	call := &failingCall{errs: []error{invalid}}
+
+	err := retryTransient(context.TODO(), call.call)
+
+	assert.Equal(t, invalid, err)
+	assert.Equal(t, 1, call.calls)
+}
+
+func Test_retryTransient_GivesUpAfterBackoffSteps(t *testing.T) {
+	withTransientBackoff(t, wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3})
+	var errs []error
+	for i := 0; i < 10; i++ {
+		errs = append(errs, k8serrors.NewInternalError(errors.New("database is locked")))
+	}
+	call := &failingCall{errs: errs}
+
+	err := retryTransient(context.TODO(), call.call)
+
+	assert.EqualError(t, err, "giving up after 3 transient errors: Internal error occurred: database is locked")
+	assert.True(t, k8serrors.IsInternalError(err))
+	assert.Equal(t, 3, call.calls)
+}
+
+func Test_retryTransient_StopsRetryingWithinDeadline(t *testing.T) {
+	var errs []error
+	for i := 0; i < 10; i++ {
+		errs = append(errs, k8serrors.NewInternalError(errors.New("database is locked")))
+	}
+	call := &failingCall{errs: errs}
+	ctx, cancel := context.WithTimeout(context.TODO(), 200*time.Millisecond)
+	defer cancel()
+
+	err := retryTransient(ctx, call.call)
+
+	assert.EqualError(t, err, fmt.Sprintf("giving up after %d transient errors: Internal error occurred: database is locked", call.calls))
+	assert.Less(t, call.calls, transientBackoff.Steps)
+	assert.NoError(t, ctx.Err(), "retries must stop before the deadline")
+}
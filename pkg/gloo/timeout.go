@@ -0,0 +1,139 @@
+package gloo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Timeouts of API calls. Zero values mean no timeout.
+type Timeouts struct {
+	// Timeout of reads of single resources
+	Get time.Duration
+	// Timeout of lists of resources
+	List time.Duration
+	// Timeout of patches and other writes
+	Patch time.Duration
+}
+
+type timeoutsKey struct{}
+
+// ContextWithTimeouts returns a context that makes clients created by this package apply the timeouts to each
+// API call made with it
+func ContextWithTimeouts(ctx context.Context, timeouts Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, timeouts)
+}
+
+func timeoutsFromContext(ctx context.Context) Timeouts {
+	timeouts, _ := ctx.Value(timeoutsKey{}).(Timeouts)
+	return timeouts
+}
+
+// timeoutClient applies the timeouts set with ContextWithTimeouts to each API call and retries calls failed with
+// transient errors within their timeouts. Creates and deletes aren't retried: a create or delete that failed with a
+// transient error may still have been done, so a retry would fail with AlreadyExists or NotFound. When a call times
+// out, the error says which call on which resource it was.
+type timeoutClient struct {
+	client.Client
+}
+
+// NewTimeoutClient wraps c with a client applying the timeouts set with ContextWithTimeouts and retrying transient
+// errors of reads, updates and patches
+func NewTimeoutClient(c client.Client) client.Client {
+	return &timeoutClient{Client: c}
+}
+
+func (c *timeoutClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.withTimeout(ctx, timeoutsFromContext(ctx).Get, true, func(ctx context.Context) error {
+		return c.Client.Get(ctx, key, obj, opts...)
+	}, func() string {
+		return fmt.Sprintf("getting %s %s", c.kind(obj), key)
+	})
+}
+
+func (c *timeoutClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.withTimeout(ctx, timeoutsFromContext(ctx).List, true, func(ctx context.Context) error {
+		return c.Client.List(ctx, list, opts...)
+	}, func() string {
+		listOpts := &client.ListOptions{}
+		listOpts.ApplyOptions(opts)
+		kind := strings.TrimSuffix(c.kind(list), "List")
+		if listOpts.Namespace == "" {
+			return fmt.Sprintf("listing %ss", kind)
+		}
+		return fmt.Sprintf("listing %ss in namespace %s", kind, listOpts.Namespace)
+	})
+}
+
+func (c *timeoutClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.withTimeout(ctx, timeoutsFromContext(ctx).Patch, false, func(ctx context.Context) error {
+		return c.Client.Create(ctx, obj, opts...)
+	}, c.describe("creating", obj))
+}
+
+func (c *timeoutClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.withTimeout(ctx, timeoutsFromContext(ctx).Patch, false, func(ctx context.Context) error {
+		return c.Client.Delete(ctx, obj, opts...)
+	}, c.describe("deleting", obj))
+}
+
+func (c *timeoutClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.withTimeout(ctx, timeoutsFromContext(ctx).Patch, true, func(ctx context.Context) error {
+		return c.Client.Update(ctx, obj, opts...)
+	}, c.describe("updating", obj))
+}
+
+func (c *timeoutClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.withTimeout(ctx, timeoutsFromContext(ctx).Patch, true, func(ctx context.Context) error {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}, c.describe("patching", obj))
+}
+
+func (c *timeoutClient) describe(operation string, obj client.Object) func() string {
+	return func() string {
+		return fmt.Sprintf("%s %s %s", operation, c.kind(obj), client.ObjectKeyFromObject(obj))
+	}
+}
+
+// withTimeout runs call with the timeout, retrying transient errors if retry is set. The error of a call that
+// exceeded the timeout is prefixed with the description of the call.
+func (c *timeoutClient) withTimeout(
+	ctx context.Context,
+	timeout time.Duration,
+	retry bool,
+	call func(ctx context.Context) error,
+	describe func() string) error {
+
+	run := call
+	if retry {
+		run = func(ctx context.Context) error {
+			return retryTransient(ctx, call)
+		}
+	}
+
+	if timeout <= 0 {
+		return run(ctx)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := run(callCtx)
+	if err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("%s timed out after %s: %w", describe(), timeout, err)
+	}
+	return err
+}
+
+func (c *timeoutClient) kind(obj runtime.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return fmt.Sprintf("%T", obj)
+	}
+	return gvk.Kind
+}
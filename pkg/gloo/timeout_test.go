@@ -0,0 +1,206 @@
+package gloo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+)
+
+// hangingClient blocks API calls until their context is done, like a client of an unresponsive API server
+type hangingClient struct {
+	client.Client
+	calls int
+}
+
+func (c *hangingClient) hang(ctx context.Context) error {
+	c.calls++
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (c *hangingClient) Get(ctx context.Context, _ client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+	return c.hang(ctx)
+}
+
+func (c *hangingClient) List(ctx context.Context, _ client.ObjectList, _ ...client.ListOption) error {
+	return c.hang(ctx)
+}
+
+func (c *hangingClient) Create(ctx context.Context, _ client.Object, _ ...client.CreateOption) error {
+	return c.hang(ctx)
+}
+
+func (c *hangingClient) Delete(ctx context.Context, _ client.Object, _ ...client.DeleteOption) error {
+	return c.hang(ctx)
+}
+
+func (c *hangingClient) Patch(ctx context.Context, _ client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	return c.hang(ctx)
+}
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	s := runtime.NewScheme()
+	require.NoError(t, gloov1.AddToScheme(s))
+	return s
+}
+
+func newTestUpstream() *gloov1.Upstream {
+	return &gloov1.Upstream{ObjectMeta: metav1.ObjectMeta{Namespace: "gloo-system", Name: "us"}}
+}
+
+func Test_timeoutClient_AppliesTimeoutOfOperation(t *testing.T) {
+	timeouts := Timeouts{Get: 10 * time.Millisecond, List: 20 * time.Millisecond, Patch: 30 * time.Millisecond}
+	key := client.ObjectKey{Namespace: "gloo-system", Name: "us"}
+
+	for name, tc := range map[string]struct {
+		call     func(ctx context.Context, c client.Client) error
+		expected string
+	}{
+		"get": {
+			call: func(ctx context.Context, c client.Client) error {
+				return c.Get(ctx, key, &gloov1.Upstream{})
+			},
+			expected: "getting Upstream gloo-system/us timed out after 10ms: context deadline exceeded",
+		},
+		"list": {
+			call: func(ctx context.Context, c client.Client) error {
+				return c.List(ctx, &gloov1.UpstreamList{}, client.InNamespace("gloo-system"))
+			},
+			expected: "listing Upstreams in namespace gloo-system timed out after 20ms: context deadline exceeded",
+		},
+		"list in all namespaces": {
+			call: func(ctx context.Context, c client.Client) error {
+				return c.List(ctx, &gloov1.UpstreamList{})
+			},
+			expected: "listing Upstreams timed out after 20ms: context deadline exceeded",
+		},
+		"patch": {
+			call: func(ctx context.Context, c client.Client) error {
+				return c.Patch(ctx, newTestUpstream(), client.MergeFrom(newTestUpstream()))
+			},
+			expected: "patching Upstream gloo-system/us timed out after 30ms: context deadline exceeded",
+		},
+		"create": {
+			call: func(ctx context.Context, c client.Client) error {
+				return c.Create(ctx, newTestUpstream())
+			},
+			expected: "creating Upstream gloo-system/us timed out after 30ms: context deadline exceeded",
+		},
+		"delete": {
+			call: func(ctx context.Context, c client.Client) error {
+				return c.Delete(ctx, newTestUpstream())
+			},
+			expected: "deleting Upstream gloo-system/us timed out after 30ms: context deadline exceeded",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			hanging := &hangingClient{Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()}
+			c := NewTimeoutClient(hanging)
+
+			err := tc.call(ContextWithTimeouts(context.Background(), timeouts), c)
+
+			assert.EqualError(t, err, tc.expected)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Equal(t, 1, hanging.calls)
+		})
+	}
+}
+
+func Test_timeoutClient_DoesNotTimeOutWithoutTimeouts(t *testing.T) {
+	upstream := newTestUpstream()
+	c := NewTimeoutClient(fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(upstream).Build())
+
+	for name, ctx := range map[string]context.Context{
+		"context without timeouts": context.Background(),
+		"zero timeouts":            ContextWithTimeouts(context.Background(), Timeouts{}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(upstream), &gloov1.Upstream{}))
+			assert.NoError(t, c.List(ctx, &gloov1.UpstreamList{}))
+		})
+	}
+}
+
+func Test_timeoutClient_DoesNotDescribeCancelledCalls(t *testing.T) {
+	c := NewTimeoutClient(&hangingClient{Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()})
+	ctx, cancel := context.WithTimeout(ContextWithTimeouts(context.Background(), Timeouts{Get: time.Minute}), 10*time.Millisecond)
+	defer cancel()
+
+	err := c.Get(ctx, client.ObjectKey{Namespace: "gloo-system", Name: "us"}, &gloov1.Upstream{})
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func Test_ContextWithTimeouts(t *testing.T) {
+	timeouts := Timeouts{Get: time.Second, List: 2 * time.Second, Patch: 3 * time.Second}
+
+	assert.Equal(t, timeouts, timeoutsFromContext(ContextWithTimeouts(context.Background(), timeouts)))
+	assert.Equal(t, Timeouts{}, timeoutsFromContext(context.Background()))
+}
+
+// unavailableClient fails the first call of each kind of write with a transient error
+type unavailableClient struct {
+	client.Client
+	calls map[string]int
+}
+
+func (c *unavailableClient) fail(operation string) error {
+	c.calls[operation]++
+	if c.calls[operation] == 1 {
+		return k8serrors.NewServiceUnavailable("etcd leader changed")
+	}
+	return nil
+}
+
+func (c *unavailableClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.fail("create"); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *unavailableClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.fail("delete"); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *unavailableClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := c.fail("patch"); err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func Test_timeoutClient_DoesNotRetryCreatesAndDeletes(t *testing.T) {
+	withTransientBackoff(t, wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 6})
+	unavailable := &unavailableClient{
+		Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(newTestUpstream()).Build(),
+		calls:  map[string]int{},
+	}
+	c := NewTimeoutClient(unavailable)
+	ctx := ContextWithTimeouts(context.Background(), Timeouts{Patch: time.Second})
+
+	assert.NoError(t, c.Patch(ctx, newTestUpstream(), client.MergeFrom(newTestUpstream())))
+	assert.Equal(t, 2, unavailable.calls["patch"])
+
+	// a create or delete failed with a transient error may have been done, so it isn't retried
+	created := newTestUpstream()
+	created.Name = "created"
+	assert.True(t, k8serrors.IsServiceUnavailable(c.Create(ctx, created)))
+	assert.Equal(t, 1, unavailable.calls["create"])
+	assert.True(t, k8serrors.IsServiceUnavailable(c.Delete(ctx, newTestUpstream())))
+	assert.Equal(t, 1, unavailable.calls["delete"])
+}
@@ -0,0 +1,434 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// routingBackend is implemented for each kind of resources that route traffic to the canary. setWeight, verifyWeight
+// and removeManagedRoutes run the same flow for all backends: the backend discovers selected resources and
+// destination pairs in them, the flow changes the destinations, verifies Upstreams and patches the changed resources.
+type routingBackend interface {
+	// discover returns the resources selected by plugin configuration with stable and canary destinations
+	// of selected routes. Resources without stable destinations are left out.
+	discover(ctx context.Context, rollout *v1alpha1.Rollout) ([]routingTarget, error)
+	// patch patches a resource of the backend
+	patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error
+	// gvk returns the kind of the resources
+	gvk() schema.GroupVersionKind
+	// managesUpstreams returns true when the Upstreams of destinations are in the cluster of the plugin, so
+	// they can be created, verified and cleaned up by the plugin
+	managesUpstreams() bool
+}
+
+// routingTarget is a resource discovered by a routingBackend. Destinations are changed in place.
+type routingTarget interface {
+	// object returns the resource
+	object() client.Object
+	// originalObject returns a copy of the resource as it was discovered
+	originalObject() client.Object
+	// namespace returns the namespace Upstream references without a namespace are resolved in
+	namespace() string
+	// destinations returns stable and canary destinations of selected routes
+	destinations() []destinationPair
+	// syncRoutes is called after destinations are changed, it replaces the routes of the resource with the
+	// routes returned by update when the resource supports routes managed by the plugin
+	syncRoutes(update func(routes []*gwv1.Route) []*gwv1.Route)
+	// changed returns true when the resource was changed since it was discovered
+	changed() bool
+	// revert sets the spec of the resource back to the spec of the original
+	revert()
+}
+
+// weightedTarget is implemented by targets whose destinations aren't Gloo Edge weighted destinations, they set
+// and verify the weights of their destinations themselves
+type weightedTarget interface {
+	// setWeight sets the weights of stable and canary destinations
+	setWeight(desiredWeight int32) error
+	// hasWeight returns true when stable and canary destinations have the weights set for desiredWeight
+	hasWeight(desiredWeight int32) bool
+}
+
+// previewBackend is implemented by backends that support the preview domain
+type previewBackend interface {
+	syncPreview(ctx context.Context, rollout *v1alpha1.Rollout, desiredWeight int32, targets []routingTarget) error
+	deletePreview(ctx context.Context, rollout *v1alpha1.Rollout, targets []routingTarget) error
+}
+
+// getRoutingBackend returns the backend for the resources selected in plugin configuration
+func (r *RpcPlugin) getRoutingBackend(pluginConfig *GlooEdgeTrafficRouting) routingBackend {
+	switch {
+	case pluginConfig.VirtualServiceSelector != nil:
+		return &virtualServiceBackend{r: r, pluginConfig: pluginConfig}
+	case pluginConfig.GatewaySelector != nil:
+		return &gatewayBackend{r: r, pluginConfig: pluginConfig}
+	case pluginConfig.FederatedVirtualServiceSelector != nil:
+		return &federatedVirtualServiceBackend{r: r, pluginConfig: pluginConfig}
+	case pluginConfig.FederatedRouteTableSelector != nil:
+		return &federatedRouteTableBackend{r: r, pluginConfig: pluginConfig}
+	case pluginConfig.PlatformRouteTableSelector != nil:
+		return &unstructuredBackend{
+			r: r, pluginConfig: pluginConfig, kind: platformRouteTableKind, selector: pluginConfig.PlatformRouteTableSelector}
+	case pluginConfig.HTTPRouteSelector != nil:
+		return &unstructuredBackend{r: r, pluginConfig: pluginConfig, kind: httpRouteKind, selector: pluginConfig.HTTPRouteSelector}
+	default:
+		return &routeTableBackend{r: r, pluginConfig: pluginConfig}
+	}
+}
+
+func (r *RpcPlugin) setWeight(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	desiredWeight int32,
+	pluginConfig *GlooEdgeTrafficRouting) error {
+
+	defer r.lockRoutingResources(rollout, pluginConfig)()
+
+	return r.setWeightUsingBackend(ctx, r.getRoutingBackend(pluginConfig), rollout, desiredWeight, pluginConfig)
+}
+
+func (r *RpcPlugin) removeManagedRoutes(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	pluginConfig *GlooEdgeTrafficRouting) error {
+
+	defer r.lockRoutingResources(rollout, pluginConfig)()
+
+	return r.removeManagedRoutesUsingBackend(ctx, r.getRoutingBackend(pluginConfig), rollout, pluginConfig)
+}
+
+// verifyWeight returns true when all the destinations selected in plugin configuration have the desired weights
+func (r *RpcPlugin) verifyWeight(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	desiredWeight int32,
+	pluginConfig *GlooEdgeTrafficRouting) (bool, error) {
+
+	backend := r.getRoutingBackend(pluginConfig)
+	targets, err := backend.discover(ctx, rollout)
+	if err != nil {
+		return false, err
+	}
+
+	for _, target := range targets {
+		var verified bool
+		if weighted, ok := target.(weightedTarget); ok {
+			verified = weighted.hasWeight(desiredWeight)
+		} else {
+			verified = destinationsHaveWeight(target.destinations(), desiredWeight)
+		}
+		if !verified {
+			r.LogCtx.Infof("weights in %s don't match desired weight %d yet", targetName(backend, target), desiredWeight)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// destinationsHaveWeight returns true when stable and canary destinations have the weights set for desiredWeight.
+// Missing canary destinations match 0 weight.
+func destinationsHaveWeight(dsts []destinationPair, desiredWeight int32) bool {
+	for _, dst := range dsts {
+		if dst.Canary == nil {
+			if desiredWeight != 0 {
+				return false
+			}
+			continue
+		}
+		if dst.Stable.GetWeight().GetValue() != uint32(100-desiredWeight) ||
+			dst.Canary.GetWeight().GetValue() != uint32(desiredWeight) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *RpcPlugin) setWeightUsingBackend(
+	ctx context.Context,
+	backend routingBackend,
+	rollout *v1alpha1.Rollout,
+	desiredWeight int32,
+	pluginConfig *GlooEdgeTrafficRouting) error {
+
+	targets, err := backend.discover(ctx, rollout)
+	if err != nil {
+		return err
+	}
+
+	canaryOptions := getCanaryDestinationOptions(rollout, pluginConfig)
+	change := func(targets []routingTarget) error {
+		for _, target := range targets {
+			dsts := []routeTableWithDestinations{{Destinations: destinationsForWeight(target.destinations(), desiredWeight)}}
+			r.maybeConvertSingleToMulti(dsts)
+			r.maybeCreateCanaryDestinations(dsts)
+			r.applyCanaryDestinationOptions(dsts, canaryOptions)
+		}
+
+		for _, target := range targets {
+			if weighted, ok := target.(weightedTarget); ok {
+				if err := weighted.setWeight(desiredWeight); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, dst := range destinationsForWeight(target.destinations(), desiredWeight) {
+				dst.Stable.Weight = &wrapperspb.UInt32Value{Value: uint32(100 - desiredWeight)}
+				dst.Canary.Weight = &wrapperspb.UInt32Value{Value: uint32(desiredWeight)}
+			}
+			target.syncRoutes(func(routes []*gwv1.Route) []*gwv1.Route {
+				return r.syncStickyRoutes(routes, target.destinations(), rollout, desiredWeight, pluginConfig)
+			})
+		}
+		return nil
+	}
+	if err = change(targets); err != nil {
+		return err
+	}
+	if targets, err = r.refreshStaleTargets(ctx, backend, rollout, targets, change); err != nil {
+		return err
+	}
+
+	if backend.managesUpstreams() {
+		// the resilience settings restored by RemoveManagedRoutes of a finished rollout aren't applied again
+		applyResilience := desiredWeight > 0 || !isRolloutFinished(rollout)
+		for _, target := range targets {
+			dsts := destinationsForWeight(target.destinations(), desiredWeight)
+			if err = r.ensureCanaryUpstreams(ctx, rollout, target.namespace(), dsts); err != nil {
+				return err
+			}
+			if err = r.verifyUpstreamsAccepted(ctx, desiredWeight, target.namespace(), dsts); err != nil {
+				return err
+			}
+			if !applyResilience {
+				continue
+			}
+			if err = r.applyCanaryUpstreamResilience(ctx, rollout, target.namespace(), dsts, pluginConfig.CanaryResilience); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = r.applyTargets(ctx, backend, targets, pluginConfig); err != nil {
+		return err
+	}
+
+	if preview, ok := backend.(previewBackend); ok {
+		return preview.syncPreview(ctx, rollout, desiredWeight, targets)
+	}
+
+	return nil
+}
+
+// destinationsForWeight returns the destination pairs that get the desired weight. With 0 weight, routes without
+// canary destinations are left as they are: Argo Rollouts sets 0 weight right after each RemoveManagedRoutes call
+// of a finished rollout, and the canary destinations and Upstreams removed by it mustn't be created again.
+func destinationsForWeight(dsts []destinationPair, desiredWeight int32) []destinationPair {
+	if desiredWeight > 0 {
+		return dsts
+	}
+	var ret []destinationPair
+	for _, dst := range dsts {
+		if dst.Canary != nil {
+			ret = append(ret, dst)
+		}
+	}
+	return ret
+}
+
+func (r *RpcPlugin) removeManagedRoutesUsingBackend(
+	ctx context.Context,
+	backend routingBackend,
+	rollout *v1alpha1.Rollout,
+	pluginConfig *GlooEdgeTrafficRouting) error {
+
+	// Argo Rollouts sets the canary weight to 100 after each RemoveManagedRoutes call while the rollout is fully
+	// promoted, the canary mustn't be removed before the promotion is finished
+	if rollout.Status.PromoteFull {
+		r.LogCtx.Debugf("rollout %s/%s is being fully promoted, leaving managed routes in place", rollout.Namespace, rollout.Name)
+		return nil
+	}
+
+	targets, err := backend.discover(ctx, rollout)
+	if err != nil {
+		return err
+	}
+
+	unusedUpstreams := map[routingTarget][]client.ObjectKey{}
+	change := func(targets []routingTarget) error {
+		unusedUpstreams = map[routingTarget][]client.ObjectKey{}
+		for _, target := range targets {
+			if backend.managesUpstreams() {
+				unused, err := r.removeManagedCanaryDestinations(ctx, rollout, target.namespace(), target.destinations())
+				if err != nil {
+					return err
+				}
+				unusedUpstreams[target] = unused
+			}
+
+			target.syncRoutes(func(routes []*gwv1.Route) []*gwv1.Route {
+				return r.syncStickyRoutes(routes, target.destinations(), rollout, 0, pluginConfig)
+			})
+		}
+		return nil
+	}
+	if err = change(targets); err != nil {
+		return err
+	}
+	if targets, err = r.refreshStaleTargets(ctx, backend, rollout, targets, change); err != nil {
+		return err
+	}
+
+	if preview, ok := backend.(previewBackend); ok {
+		if err = preview.deletePreview(ctx, rollout, targets); err != nil {
+			return err
+		}
+	}
+
+	var changedTargets []routingTarget
+	var allUnusedUpstreams []client.ObjectKey
+	for _, target := range targets {
+		if backend.managesUpstreams() {
+			if err = r.restoreCanaryUpstreams(ctx, target.namespace(), target.destinations()); err != nil {
+				return err
+			}
+		}
+		if !target.changed() {
+			continue
+		}
+
+		changedTargets = append(changedTargets, target)
+		allUnusedUpstreams = append(allUnusedUpstreams, unusedUpstreams[target]...)
+	}
+
+	if err = r.applyTargets(ctx, backend, changedTargets, pluginConfig); err != nil {
+		return err
+	}
+
+	return r.deleteManagedUpstreams(ctx, allUnusedUpstreams)
+}
+
+// applyTargets patches changed resources all or nothing, at most patchConcurrency of them at the same time, and
+// skips unchanged ones. When there are multiple resources, all the patches are validated with a dry run first. When
+// patches fail anyway, the resources patched successfully are reverted to their original specs.
+func (r *RpcPlugin) applyTargets(
+	ctx context.Context,
+	backend routingBackend,
+	targets []routingTarget,
+	pluginConfig *GlooEdgeTrafficRouting) error {
+
+	targets = r.skipUnchangedTargets(backend, targets)
+
+	concurrency := getPatchConcurrency(pluginConfig)
+
+	if len(targets) > 1 {
+		errs := forEachTarget(targets, concurrency, func(target routingTarget) error {
+			obj := target.object().DeepCopyObject().(client.Object)
+			patch, opts := routingPatch(target.originalObject(), backend.gvk(), pluginConfig)
+			if err := backend.patch(ctx, obj, patch, append(opts, client.DryRunAll)...); err != nil {
+				return fmt.Errorf("validation of %s failed, no resources were changed: %w", targetName(backend, target), err)
+			}
+			return nil
+		})
+		if err := collectTargetErrors(errs); err != nil {
+			return err
+		}
+	}
+
+	errs := forEachTarget(targets, concurrency, func(target routingTarget) error {
+		patch, opts := routingPatch(target.originalObject(), backend.gvk(), pluginConfig)
+		if err := backend.patch(ctx, target.object(), patch, opts...); err != nil {
+			return fmt.Errorf("%s: %w", targetName(backend, target), err)
+		}
+		return nil
+	})
+	err := collectTargetErrors(errs)
+	if err == nil {
+		return nil
+	}
+
+	var patched []routingTarget
+	for i, target := range targets {
+		if errs[i] == nil {
+			patched = append(patched, target)
+		}
+	}
+	return r.revertTargets(ctx, backend, patched, pluginConfig, err)
+}
+
+// skipUnchangedTargets returns the targets changed since they were discovered. Patching the others would only
+// bump their resourceVersion and make Gloo recompute the proxy configuration.
+func (r *RpcPlugin) skipUnchangedTargets(backend routingBackend, targets []routingTarget) []routingTarget {
+	var changed []routingTarget
+	for _, target := range targets {
+		if !target.changed() {
+			r.LogCtx.Debugf("skipping patch of %s, it's already up to date", targetName(backend, target))
+			continue
+		}
+		changed = append(changed, target)
+	}
+	return changed
+}
+
+// collectTargetErrors returns the non-nil errors returned by forEachTarget as a single error
+func collectTargetErrors(errs []error) error {
+	var failed targetErrors
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		return failed
+	}
+}
+
+// revertTargets reverts patched resources to their original specs after the update of other resources failed
+func (r *RpcPlugin) revertTargets(
+	ctx context.Context,
+	backend routingBackend,
+	targets []routingTarget,
+	pluginConfig *GlooEdgeTrafficRouting,
+	cause error) error {
+
+	if len(targets) == 0 {
+		return cause
+	}
+
+	errs := forEachTarget(targets, getPatchConcurrency(pluginConfig), func(target routingTarget) error {
+		current := target.object().DeepCopyObject().(client.Object)
+		target.revert()
+		patch, opts := routingPatch(current, backend.gvk(), pluginConfig)
+		return backend.patch(ctx, target.object(), patch, opts...)
+	})
+
+	var reverted, failed []string
+	for i, target := range targets {
+		if errs[i] != nil {
+			r.LogCtx.Errorf("failed to revert %s: %s", targetName(backend, target), errs[i])
+			failed = append(failed, targetName(backend, target))
+			continue
+		}
+		reverted = append(reverted, targetName(backend, target))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%w (reverted: %v, failed to revert: %v)", cause, reverted, failed)
+	}
+	return fmt.Errorf("%w (reverted: %v)", cause, reverted)
+}
+
+func targetName(backend routingBackend, target routingTarget) string {
+	return fmt.Sprintf("%s %s/%s", backend.gvk().Kind, target.object().GetNamespace(), target.object().GetName())
+}
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getRoutingBackend(t *testing.T) {
+	r := &RpcPlugin{}
+	selector := &DumbObjectSelector{Name: "name"}
+
+	assert.IsType(t, &virtualServiceBackend{}, r.getRoutingBackend(&GlooEdgeTrafficRouting{VirtualServiceSelector: selector}))
+	assert.IsType(t, &routeTableBackend{}, r.getRoutingBackend(&GlooEdgeTrafficRouting{RouteTableSelector: selector}))
+	assert.IsType(t, &gatewayBackend{}, r.getRoutingBackend(&GlooEdgeTrafficRouting{GatewaySelector: selector}))
+	assert.IsType(t, &federatedVirtualServiceBackend{},
+		r.getRoutingBackend(&GlooEdgeTrafficRouting{FederatedVirtualServiceSelector: selector}))
+	assert.IsType(t, &federatedRouteTableBackend{},
+		r.getRoutingBackend(&GlooEdgeTrafficRouting{FederatedRouteTableSelector: selector}))
+	assert.Equal(t, platformRouteTableKind,
+		r.getRoutingBackend(&GlooEdgeTrafficRouting{PlatformRouteTableSelector: selector}).(*unstructuredBackend).kind)
+	assert.Equal(t, httpRouteKind, r.getRoutingBackend(&GlooEdgeTrafficRouting{HTTPRouteSelector: selector}).(*unstructuredBackend).kind)
+
+	_, isPreviewBackend := r.getRoutingBackend(&GlooEdgeTrafficRouting{VirtualServiceSelector: selector}).(previewBackend)
+	assert.True(t, isPreviewBackend)
+}
+
+func Test_destinationsHaveWeight(t *testing.T) {
+	for name, tc := range map[string]struct {
+		dsts          []destinationPair
+		desiredWeight int32
+		expected      bool
+	}{
+		"matching weights": {
+			dsts: []destinationPair{
+				{Stable: newUpstreamDestination("stable", "", 70), Canary: newUpstreamDestination("canary", "", 30)},
+			},
+			desiredWeight: 30,
+			expected:      true,
+		},
+		"stale weights": {
+			dsts: []destinationPair{
+				{Stable: newUpstreamDestination("stable", "", 70), Canary: newUpstreamDestination("canary", "", 30)},
+				{Stable: newUpstreamDestination("stable", "", 90), Canary: newUpstreamDestination("canary", "", 10)},
+			},
+			desiredWeight: 30,
+		},
+		"missing canary with 0 weight": {
+			dsts:          []destinationPair{{Stable: newUpstreamDestination("stable", "", 100)}},
+			desiredWeight: 0,
+			expected:      true,
+		},
+		"missing canary": {
+			dsts:          []destinationPair{{Stable: newUpstreamDestination("stable", "", 100)}},
+			desiredWeight: 10,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, destinationsHaveWeight(tc.dsts, tc.desiredWeight))
+		})
+	}
+}
+
+// objectEq matches objects with the same JSON representation. Unlike gomock.Eq, it ignores the internal state of
+// protobuf messages, which changes when messages are copied or marshalled.
+func objectEq(expected interface{}) gomock.Matcher {
+	return &objectMatcher{expected: expected}
+}
+
+type objectMatcher struct {
+	expected interface{}
+}
+
+func (m *objectMatcher) Matches(x interface{}) bool {
+	var expected, actual interface{}
+	if err := roundTripJSON(m.expected, &expected); err != nil {
+		return false
+	}
+	if err := roundTripJSON(x, &actual); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(expected, actual)
+}
+
+func (m *objectMatcher) String() string {
+	return fmt.Sprintf("has the same JSON representation as %v", m.expected)
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// cachedBackend is implemented by backends that discover resources from the informer cache when it's used
+type cachedBackend interface {
+	// getLive reads the resource from the API server. Returns nil when resources aren't read from the cache.
+	getLive(ctx context.Context, key client.ObjectKey) (client.Object, error)
+	// discoverFrom returns the targets in the resources, like discover does with the resources it reads
+	discoverFrom(rollout *v1alpha1.Rollout, objs []client.Object) ([]routingTarget, error)
+}
+
+// routeTableReader returns the reader serving RouteTables from the informer cache when it's used
+func (r *RpcPlugin) routeTableReader() gwv1.RouteTableReader {
+	if cached := r.Client.CachedRouteTables(); cached != nil {
+		return cached
+	}
+	return r.Client.RouteTables()
+}
+
+// virtualServiceReader returns the reader serving VirtualServices from the informer cache when it's used
+func (r *RpcPlugin) virtualServiceReader() gwv1.VirtualServiceReader {
+	if cached := r.Client.CachedVirtualServices(); cached != nil {
+		return cached
+	}
+	return r.Client.VirtualServices()
+}
+
+// refreshStaleTargets makes sure that the changes of resources discovered from the informer cache aren't computed
+// from stale resources. The changes are computed from the cached resources first with change, and only the resources
+// that are going to be patched are read from the API server. When the cache lags behind, the targets are discovered
+// again in the resources read from the API server and the changes are computed again.
+func (r *RpcPlugin) refreshStaleTargets(
+	ctx context.Context,
+	backend routingBackend,
+	rollout *v1alpha1.Rollout,
+	targets []routingTarget,
+	change func(targets []routingTarget) error) ([]routingTarget, error) {
+
+	cached, ok := backend.(cachedBackend)
+	if !ok {
+		return targets, nil
+	}
+
+	stale := false
+	objs := make([]client.Object, len(targets))
+	for i, target := range targets {
+		if !target.changed() {
+			objs[i] = target.originalObject().DeepCopyObject().(client.Object)
+			continue
+		}
+		obj, err := cached.getLive(ctx, client.ObjectKeyFromObject(target.object()))
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			return targets, nil
+		}
+		if obj.GetResourceVersion() != target.object().GetResourceVersion() {
+			r.LogCtx.Debugf("cached %s is older than resource version %s, using the resource read from the API server",
+				targetName(backend, target), obj.GetResourceVersion())
+			stale = true
+		}
+		objs[i] = obj
+	}
+	if !stale {
+		return targets, nil
+	}
+
+	targets, err := cached.discoverFrom(rollout, objs)
+	if err != nil {
+		return nil, err
+	}
+	if err = change(targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
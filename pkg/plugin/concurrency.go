@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"strings"
+	"sync"
+)
+
+// defaultPatchConcurrency is the maximum number of routing resources patched at the same time when not configured
+const defaultPatchConcurrency = 8
+
+func getPatchConcurrency(pluginConfig *GlooEdgeTrafficRouting) int {
+	if pluginConfig.PatchConcurrency > 0 {
+		return int(pluginConfig.PatchConcurrency)
+	}
+	return defaultPatchConcurrency
+}
+
+// forEachTarget runs fn for each target with at most concurrency calls running at the same time. It returns
+// the errors of the calls indexed like the targets, nil errors are returned for successful calls.
+func forEachTarget(targets []routingTarget, concurrency int, fn func(target routingTarget) error) []error {
+	errs := make([]error, len(targets))
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, target routingTarget) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = fn(target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// targetErrors are errors of resources patched at the same time, errors.Is and errors.As match any of them
+type targetErrors []error
+
+func (e targetErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e targetErrors) Unwrap() []error {
+	return e
+}
@@ -0,0 +1,325 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	fedgwv1 "github.com/solo-io/solo-apis/pkg/api/fed.gateway.solo.io/v1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	"github.com/solo-io/solo-apis/pkg/api/multicluster.solo.io/v1alpha1/types"
+	"golang.org/x/exp/slices"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Federated resources are handled by changing their templates, so the weights are changed in all the clusters
+// the resources are placed to. Upstreams live in the placement clusters, so they're neither created nor checked
+// by the plugin.
+
+type federatedVirtualServiceBackend struct {
+	r            *RpcPlugin
+	pluginConfig *GlooEdgeTrafficRouting
+}
+
+type federatedVirtualServiceTarget struct {
+	fvs      *fedgwv1.FederatedVirtualService
+	original *fedgwv1.FederatedVirtualService
+	dsts     []destinationPair
+}
+
+func (b *federatedVirtualServiceBackend) discover(ctx context.Context, rollout *v1alpha1.Rollout) ([]routingTarget, error) {
+	fvs, err := b.r.getFederatedVirtualService(ctx, rollout, b.pluginConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	original := &fedgwv1.FederatedVirtualService{}
+	fvs.DeepCopyInto(original)
+
+	routes := fvs.Spec.GetTemplate().GetSpec().GetVirtualHost().GetRoutes()
+	if routes == nil {
+		return nil, fmt.Errorf("no virtual host or empty routes in template of FederatedVirtualService %s/%s", fvs.GetNamespace(), fvs.GetName())
+	}
+
+	dsts, err := b.r.getDestinationsInFederatedRoutes(rollout, b.pluginConfig, routes, true)
+	if err != nil {
+		return nil, fmt.Errorf("FederatedVirtualService %s/%s: %w", fvs.GetNamespace(), fvs.GetName(), err)
+	}
+	if len(dsts) == 0 {
+		return nil, fmt.Errorf("couldn't find stable upstreams in FederatedVirtualService %s/%s, with route names in %v",
+			fvs.GetNamespace(), fvs.GetName(), b.pluginConfig.Routes)
+	}
+
+	return []routingTarget{&federatedVirtualServiceTarget{fvs: fvs, original: original, dsts: dsts}}, nil
+}
+
+func (b *federatedVirtualServiceBackend) patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return b.r.Client.FederatedVirtualServices().PatchFederatedVirtualService(ctx, obj.(*fedgwv1.FederatedVirtualService), patch, opts...)
+}
+
+func (b *federatedVirtualServiceBackend) gvk() schema.GroupVersionKind {
+	return fedgwv1.FederatedVirtualServiceGVK
+}
+
+func (b *federatedVirtualServiceBackend) managesUpstreams() bool {
+	return false
+}
+
+func (t *federatedVirtualServiceTarget) object() client.Object {
+	return t.fvs
+}
+
+func (t *federatedVirtualServiceTarget) originalObject() client.Object {
+	return t.original
+}
+
+func (t *federatedVirtualServiceTarget) revert() {
+	t.original.Spec.DeepCopyInto(&t.fvs.Spec)
+}
+
+func (t *federatedVirtualServiceTarget) namespace() string {
+	return t.fvs.GetNamespace()
+}
+
+func (t *federatedVirtualServiceTarget) destinations() []destinationPair {
+	return t.dsts
+}
+
+func (t *federatedVirtualServiceTarget) syncRoutes(update func(routes []*gwv1.Route) []*gwv1.Route) {
+	t.fvs.Spec.Template.Spec.VirtualHost.Routes = update(t.fvs.Spec.GetTemplate().GetSpec().GetVirtualHost().GetRoutes())
+}
+
+func (t *federatedVirtualServiceTarget) changed() bool {
+	return !t.fvs.Spec.Equal(&t.original.Spec)
+}
+
+type federatedRouteTableBackend struct {
+	r            *RpcPlugin
+	pluginConfig *GlooEdgeTrafficRouting
+}
+
+type federatedRouteTableTarget struct {
+	frt      *fedgwv1.FederatedRouteTable
+	original *fedgwv1.FederatedRouteTable
+	dsts     []destinationPair
+}
+
+func (b *federatedRouteTableBackend) discover(ctx context.Context, rollout *v1alpha1.Rollout) ([]routingTarget, error) {
+	frts, err := b.r.getFederatedRouteTables(ctx, rollout, b.pluginConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []routingTarget
+	for _, frt := range frts {
+		routes := frt.Spec.GetTemplate().GetSpec().GetRoutes()
+		if routes == nil {
+			continue
+		}
+
+		dsts, err := b.r.getDestinationsInFederatedRoutes(rollout, b.pluginConfig, routes, false)
+		if err != nil {
+			return nil, fmt.Errorf("FederatedRouteTable %s/%s: %w", frt.GetNamespace(), frt.GetName(), err)
+		}
+		if len(dsts) == 0 {
+			continue
+		}
+
+		original := &fedgwv1.FederatedRouteTable{}
+		frt.DeepCopyInto(original)
+		ret = append(ret, &federatedRouteTableTarget{frt: frt, original: original, dsts: dsts})
+	}
+
+	if len(ret) == 0 {
+		selector := b.pluginConfig.FederatedRouteTableSelector
+		return nil, fmt.Errorf("couldn't find stable upstreams in FederatedRouteTables selected with Name: '%s', Namespace: '%s', Labels: %v, placed in clusters %v, with route names in %v",
+			selector.Name, selector.Namespace, selector.Labels, b.pluginConfig.FederationClusters, b.pluginConfig.Routes)
+	}
+
+	return ret, nil
+}
+
+func (b *federatedRouteTableBackend) patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return b.r.Client.FederatedRouteTables().PatchFederatedRouteTable(ctx, obj.(*fedgwv1.FederatedRouteTable), patch, opts...)
+}
+
+func (b *federatedRouteTableBackend) gvk() schema.GroupVersionKind {
+	return fedgwv1.FederatedRouteTableGVK
+}
+
+func (b *federatedRouteTableBackend) managesUpstreams() bool {
+	return false
+}
+
+func (t *federatedRouteTableTarget) object() client.Object {
+	return t.frt
+}
+
+func (t *federatedRouteTableTarget) originalObject() client.Object {
+	return t.original
+}
+
+func (t *federatedRouteTableTarget) revert() {
+	t.original.Spec.DeepCopyInto(&t.frt.Spec)
+}
+
+func (t *federatedRouteTableTarget) namespace() string {
+	return t.frt.GetNamespace()
+}
+
+func (t *federatedRouteTableTarget) destinations() []destinationPair {
+	return t.dsts
+}
+
+func (t *federatedRouteTableTarget) syncRoutes(update func(routes []*gwv1.Route) []*gwv1.Route) {
+	t.frt.Spec.Template.Spec.Routes = update(t.frt.Spec.GetTemplate().GetSpec().GetRoutes())
+}
+
+func (t *federatedRouteTableTarget) changed() bool {
+	return !t.frt.Spec.Equal(&t.original.Spec)
+}
+
+// getDestinationsInFederatedRoutes returns destinations in routes of a template. When allRoutes is set, e.g. for
+// the template of a FederatedVirtualService, every route specified in the canary config must be in the template,
+// while routes may be spread across the templates of FederatedRouteTables otherwise.
+func (r *RpcPlugin) getDestinationsInFederatedRoutes(
+	rollout *v1alpha1.Rollout,
+	pluginConfig *GlooEdgeTrafficRouting,
+	routes []*gwv1.Route,
+	allRoutes bool) (ret []destinationPair, err error) {
+
+	routes = unmanagedRoutes(routes)
+	for _, mapping := range getUpstreamMappings(rollout, pluginConfig) {
+		if len(routes) > 1 && len(mapping.Routes) == 0 {
+			return nil, fmt.Errorf("template has multiple routes but canary config doesn't specify which routes to use")
+		}
+
+		dsts := r.getMappedDestinationsInRoutes(routes, mapping)
+
+		if allRoutes && len(mapping.Routes) > 0 && len(dsts) != len(mapping.Routes) {
+			return nil, fmt.Errorf("some/all routes specified in canary rollout configuration do not have stable upstreams")
+		}
+
+		ret = append(ret, dsts...)
+	}
+
+	return ret, nil
+}
+
+func (r *RpcPlugin) getFederatedVirtualService(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	pluginConfig *GlooEdgeTrafficRouting) (*fedgwv1.FederatedVirtualService, error) {
+
+	namespace := pluginConfig.FederatedVirtualServiceSelector.Namespace
+
+	if namespace == "" {
+		r.LogCtx.Debugf("defaulting FederatedVirtualService selector namespace to Rollout namespace %s for rollout %s", rollout.Namespace, rollout.Name)
+		namespace = rollout.Namespace
+	}
+
+	if pluginConfig.FederatedVirtualServiceSelector.Name == "" {
+		return nil, fmt.Errorf("must specify the name of the FederatedVirtualService")
+	}
+
+	fvs, err := r.Client.FederatedVirtualServices().GetFederatedVirtualService(ctx,
+		client.ObjectKey{Namespace: namespace, Name: pluginConfig.FederatedVirtualServiceSelector.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	if !isPlacedInClusters(fvs.Spec.GetPlacement(), pluginConfig.FederationClusters) {
+		return nil, fmt.Errorf("FederatedVirtualService %s/%s isn't placed in any of clusters %v",
+			fvs.GetNamespace(), fvs.GetName(), pluginConfig.FederationClusters)
+	}
+	if err = checkPlacedInClustersOnly("FederatedVirtualService", fvs, fvs.Spec.GetPlacement(), pluginConfig.FederationClusters); err != nil {
+		return nil, err
+	}
+
+	return fvs, nil
+}
+
+func (r *RpcPlugin) getFederatedRouteTables(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	pluginConfig *GlooEdgeTrafficRouting) ([]*fedgwv1.FederatedRouteTable, error) {
+
+	namespace := pluginConfig.FederatedRouteTableSelector.Namespace
+
+	if namespace == "" {
+		r.LogCtx.Debugf("defaulting FederatedRouteTable selector namespace to Rollout namespace %s for rollout %s", rollout.Namespace, rollout.Name)
+		namespace = rollout.Namespace
+	}
+
+	if pluginConfig.FederatedRouteTableSelector.Name == "" && len(pluginConfig.FederatedRouteTableSelector.Labels) == 0 {
+		return nil, fmt.Errorf("name or labels field must be set in FederatedRouteTable selector")
+	}
+
+	var frts []*fedgwv1.FederatedRouteTable
+	if pluginConfig.FederatedRouteTableSelector.Name != "" {
+		frt, err := r.Client.FederatedRouteTables().GetFederatedRouteTable(ctx,
+			client.ObjectKey{Namespace: namespace, Name: pluginConfig.FederatedRouteTableSelector.Name})
+		if err != nil {
+			return nil, err
+		}
+		frts = append(frts, frt)
+	} else {
+		list, err := r.Client.FederatedRouteTables().ListFederatedRouteTable(ctx,
+			client.MatchingLabels(pluginConfig.FederatedRouteTableSelector.Labels),
+			client.InNamespace(namespace))
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			frts = append(frts, &list.Items[i])
+		}
+	}
+
+	var ret []*fedgwv1.FederatedRouteTable
+	for _, frt := range frts {
+		if !isPlacedInClusters(frt.Spec.GetPlacement(), pluginConfig.FederationClusters) {
+			continue
+		}
+		if err := checkPlacedInClustersOnly("FederatedRouteTable", frt, frt.Spec.GetPlacement(), pluginConfig.FederationClusters); err != nil {
+			return nil, err
+		}
+		ret = append(ret, frt)
+	}
+
+	return ret, nil
+}
+
+// isPlacedInClusters returns true when a federated resource is placed in any of the clusters, or when no
+// clusters are given
+func isPlacedInClusters(placement *types.Placement, clusters []string) bool {
+	if len(clusters) == 0 {
+		return true
+	}
+	for _, cluster := range placement.GetClusters() {
+		if cluster == "*" || slices.Contains(clusters, cluster) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPlacedInClustersOnly returns an error when a federated resource selected by federationClusters is also
+// placed in other clusters. Weights are changed in the template, which Gloo Fed propagates to all the placement
+// clusters, so they can't be set for some of the clusters only.
+func checkPlacedInClustersOnly(kind string, obj client.Object, placement *types.Placement, clusters []string) error {
+	if len(clusters) == 0 {
+		return nil
+	}
+	var others []string
+	for _, cluster := range placement.GetClusters() {
+		if !slices.Contains(clusters, cluster) {
+			others = append(others, cluster)
+		}
+	}
+	if len(others) > 0 {
+		return fmt.Errorf("%s %s/%s is also placed in clusters %v that aren't listed in federationClusters, weights of federated resources apply to all their placement clusters and can't be set per cluster",
+			kind, obj.GetNamespace(), obj.GetName(), others)
+	}
+	return nil
+}
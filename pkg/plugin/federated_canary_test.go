@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	fedgwv1 "github.com/solo-io/solo-apis/pkg/api/fed.gateway.solo.io/v1"
+	fedmocks "github.com/solo-io/solo-apis/pkg/api/fed.gateway.solo.io/v1/mocks"
+	fedtypes "github.com/solo-io/solo-apis/pkg/api/fed.gateway.solo.io/v1/types"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+	"github.com/solo-io/solo-apis/pkg/api/multicluster.solo.io/v1alpha1/types"
+)
+
+type FederatedCanarySuite struct {
+	suite.Suite
+	plugin     *RpcPlugin
+	ctrl       *gomock.Controller
+	ctx        context.Context
+	gwclient   *gloov1.MockClientset
+	glooclient *gloomocks.MockClientset
+	fedclient  *fedmocks.MockClientset
+	fvsclient  *fedmocks.MockFederatedVirtualServiceClient
+	frtclient  *fedmocks.MockFederatedRouteTableClient
+	loggerHook *test.Hook
+}
+
+func (s *FederatedCanarySuite) SetupTest() {
+	s.ctx = context.TODO()
+	s.ctrl = gomock.NewController(s.T())
+	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	s.fedclient = fedmocks.NewMockClientset(s.ctrl)
+	s.fvsclient = fedmocks.NewMockFederatedVirtualServiceClient(s.ctrl)
+	s.frtclient = fedmocks.NewMockFederatedRouteTableClient(s.ctrl)
+	var testLogger *logrus.Logger
+	testLogger, s.loggerHook = test.NewNullLogger()
+	s.plugin = &RpcPlugin{
+		Client: gloo.NewGlooV1ClientSetFromClients(s.gwclient, s.glooclient, s.fedclient, nil),
+		LogCtx: testLogger.WithContext(s.ctx),
+	}
+}
+
+func TestFederatedCanarySuite(t *testing.T) {
+	suite.Run(t, new(FederatedCanarySuite))
+}
+
+func newSingleUpstreamRoute(name, upstream string) *gwv1.Route {
+	return &gwv1.Route{
+		Name: name,
+		Action: &gwv1.Route_RouteAction{
+			RouteAction: &v1.RouteAction{
+				Destination: &v1.RouteAction_Single{
+					Single: newUpstreamDestination(upstream, "", 0).GetDestination(),
+				},
+			},
+		},
+	}
+}
+
+func newFederatedVirtualService(clusters []string, routes ...*gwv1.Route) *fedgwv1.FederatedVirtualService {
+	return &fedgwv1.FederatedVirtualService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "gloo-system", Name: "fvs"},
+		Spec: fedtypes.FederatedVirtualServiceSpec{
+			Template: &fedtypes.FederatedVirtualServiceSpec_Template{
+				Spec: &gwv1.VirtualServiceSpec{VirtualHost: &gwv1.VirtualHost{Routes: routes}},
+			},
+			Placement: &types.Placement{Clusters: clusters, Namespaces: []string{"gloo-system"}},
+		},
+	}
+}
+
+func (s *FederatedCanarySuite) Test_handleCanary_UsingFederatedVirtualService() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	fvs := newFederatedVirtualService([]string{"east", "west"}, newSingleUpstreamRoute("echo", "stablesvc"))
+
+	s.fvsclient.EXPECT().GetFederatedVirtualService(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "fvs"})).Times(1).Return(fvs, nil)
+	s.fvsclient.EXPECT().PatchFederatedVirtualService(gomock.Any(), gomock.Any(), gomock.Any()).Times(1)
+	s.fedclient.EXPECT().FederatedVirtualServices().Return(s.fvsclient).Times(2)
+
+	err := s.plugin.setWeight(s.ctx, rollout, 10, &GlooEdgeTrafficRouting{
+		FederatedVirtualServiceSelector: &DumbObjectSelector{Namespace: "gloo-system", Name: "fvs"},
+		FederationClusters:              []string{"east", "west"},
+	})
+
+	assert.NoError(s.T(), err)
+	dsts := fvs.Spec.GetTemplate().GetSpec().GetVirtualHost().GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()
+	assert.Len(s.T(), dsts, 2)
+	assert.Equal(s.T(), "stablesvc", dsts[0].GetDestination().GetUpstream().GetName())
+	assert.Equal(s.T(), uint32(90), dsts[0].GetWeight().GetValue())
+	assert.Equal(s.T(), "canarysvc", dsts[1].GetDestination().GetUpstream().GetName())
+	assert.Equal(s.T(), uint32(10), dsts[1].GetWeight().GetValue())
+}
+
+func (s *FederatedCanarySuite) Test_setWeight_FailsWhenRoutesAreMissingInFederatedVirtualService() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	fvs := newFederatedVirtualService([]string{"east"},
+		newSingleUpstreamRoute("echo", "stablesvc"), newSingleUpstreamRoute("other", "othersvc"))
+
+	s.fvsclient.EXPECT().GetFederatedVirtualService(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "fvs"})).Times(1).Return(fvs, nil)
+	s.fvsclient.EXPECT().PatchFederatedVirtualService(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	s.fedclient.EXPECT().FederatedVirtualServices().Return(s.fvsclient).Times(1)
+
+	err := s.plugin.setWeight(s.ctx, rollout, 10, &GlooEdgeTrafficRouting{
+		FederatedVirtualServiceSelector: &DumbObjectSelector{Namespace: "gloo-system", Name: "fvs"},
+		FederationClusters:              []string{"east"},
+		Routes:                          []string{"echo", "missing"},
+	})
+
+	assert.EqualError(s.T(), err,
+		"FederatedVirtualService gloo-system/fvs: some/all routes specified in canary rollout configuration do not have stable upstreams")
+	assert.Nil(s.T(), fvs.Spec.GetTemplate().GetSpec().GetVirtualHost().GetRoutes()[0].GetRouteAction().GetMulti())
+}
+
+func (s *FederatedCanarySuite) Test_getFederatedVirtualService_NotPlacedInClusters() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	fvs := newFederatedVirtualService([]string{"east"}, newSingleUpstreamRoute("echo", "stablesvc"))
+
+	s.fvsclient.EXPECT().GetFederatedVirtualService(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "rollout-ns", Name: "fvs"})).Times(1).Return(fvs, nil)
+	s.fedclient.EXPECT().FederatedVirtualServices().Return(s.fvsclient).Times(1)
+
+	_, err := s.plugin.getFederatedVirtualService(s.ctx, rollout, &GlooEdgeTrafficRouting{
+		FederatedVirtualServiceSelector: &DumbObjectSelector{Name: "fvs"},
+		FederationClusters:              []string{"west"},
+	})
+
+	assert.EqualError(s.T(), err, "FederatedVirtualService gloo-system/fvs isn't placed in any of clusters [west]")
+}
+
+func (s *FederatedCanarySuite) Test_handleCanary_UsingFederatedRouteTables() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	newFrt := func(name string, clusters []string) fedgwv1.FederatedRouteTable {
+		return fedgwv1.FederatedRouteTable{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "rollout-ns", Name: name},
+			Spec: fedtypes.FederatedRouteTableSpec{
+				Template: &fedtypes.FederatedRouteTableSpec_Template{
+					Spec: &gwv1.RouteTableSpec{Routes: []*gwv1.Route{newSingleUpstreamRoute("echo", "stablesvc")}},
+				},
+				Placement: &types.Placement{Clusters: clusters},
+			},
+		}
+	}
+	list := &fedgwv1.FederatedRouteTableList{Items: []fedgwv1.FederatedRouteTable{
+		newFrt("west", []string{"west"}),
+		newFrt("east", []string{"east"}),
+	}}
+
+	s.frtclient.EXPECT().ListFederatedRouteTable(gomock.Any(), gomock.Any()).Times(1).Return(list, nil)
+	s.frtclient.EXPECT().PatchFederatedRouteTable(gomock.Any(), gomock.Any(), gomock.Any()).Times(1)
+	s.fedclient.EXPECT().FederatedRouteTables().Return(s.frtclient).Times(2)
+
+	err := s.plugin.setWeight(s.ctx, rollout, 30, &GlooEdgeTrafficRouting{
+		FederatedRouteTableSelector: &DumbObjectSelector{Labels: map[string]string{"app": "echo"}},
+		FederationClusters:          []string{"west"},
+	})
+
+	assert.NoError(s.T(), err)
+	dsts := list.Items[0].Spec.GetTemplate().GetSpec().GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()
+	assert.Len(s.T(), dsts, 2)
+	assert.Equal(s.T(), uint32(70), dsts[0].GetWeight().GetValue())
+	assert.Equal(s.T(), uint32(30), dsts[1].GetWeight().GetValue())
+	assert.NotNil(s.T(), list.Items[1].Spec.GetTemplate().GetSpec().GetRoutes()[0].GetRouteAction().GetSingle())
+}
+
+func (s *FederatedCanarySuite) Test_getFederatedVirtualService_PlacedInOtherClusters() {
+	type testCase struct {
+		clusters      []string
+		otherClusters string
+	}
+	for name, tc := range map[string]testCase{
+		"listed and other clusters": {clusters: []string{"east", "west"}, otherClusters: "[east]"},
+		"all clusters":              {clusters: []string{"*"}, otherClusters: "[*]"},
+	} {
+		s.Run(name, func() {
+			s.SetupTest()
+			fvs := newFederatedVirtualService(tc.clusters, newSingleUpstreamRoute("echo", "stablesvc"))
+
+			s.fvsclient.EXPECT().GetFederatedVirtualService(gomock.Any(), gomock.Any()).Times(1).Return(fvs, nil)
+			s.fedclient.EXPECT().FederatedVirtualServices().Return(s.fvsclient).Times(1)
+
+			_, err := s.plugin.getFederatedVirtualService(s.ctx, newTestRollout("stablesvc", "canarysvc"), &GlooEdgeTrafficRouting{
+				FederatedVirtualServiceSelector: &DumbObjectSelector{Name: "fvs"},
+				FederationClusters:              []string{"west"},
+			})
+
+			assert.EqualError(s.T(), err, "FederatedVirtualService gloo-system/fvs is also placed in clusters "+tc.otherClusters+
+				" that aren't listed in federationClusters, weights of federated resources apply to all their placement clusters and can't be set per cluster")
+		})
+	}
+}
+
+func Test_isPlacedInClusters(t *testing.T) {
+	assert.True(t, isPlacedInClusters(&types.Placement{Clusters: []string{"east"}}, nil))
+	assert.True(t, isPlacedInClusters(&types.Placement{Clusters: []string{"*"}}, []string{"west"}))
+	assert.True(t, isPlacedInClusters(&types.Placement{Clusters: []string{"east", "west"}}, []string{"west"}))
+	assert.False(t, isPlacedInClusters(&types.Placement{Clusters: []string{"east"}}, []string{"west"}))
+	assert.False(t, isPlacedInClusters(nil, []string{"west"}))
+}
+
+func (s *FederatedCanarySuite) Test_SetWeight_RetriesOnConflict() {
+	fvs := newFederatedVirtualService(nil, newSingleUpstreamRoute("echo", "stablesvc"))
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	rollout.Spec.Strategy.Canary.TrafficRouting = &v1alpha1.RolloutTrafficRouting{
+		Plugins: map[string]json.RawMessage{
+			PluginName: []byte(`{"federatedVirtualService": {"name": "fvs", "namespace": "gloo-system"}}`),
+		},
+	}
+
+	s.fvsclient.EXPECT().GetFederatedVirtualService(gomock.Any(), gomock.Any()).Times(2).DoAndReturn(
+		func(context.Context, client.ObjectKey) (*fedgwv1.FederatedVirtualService, error) {
+			return fvs.DeepCopy(), nil
+		})
+	gomock.InOrder(
+		s.fvsclient.EXPECT().PatchFederatedVirtualService(gomock.Any(), gomock.Any(), gomock.Any()).Return(newConflict()),
+		s.fvsclient.EXPECT().PatchFederatedVirtualService(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil),
+	)
+	s.fedclient.EXPECT().FederatedVirtualServices().Return(s.fvsclient).Times(4)
+
+	err := s.plugin.SetWeight(rollout, 10, nil)
+
+	assert.Empty(s.T(), err.ErrorString)
+}
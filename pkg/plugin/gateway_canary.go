@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// tcpHostRoute wraps the action of a TCP host into a route, so that TCP hosts can be handled the same way
+// as routes. The route shares destinations with the TCP host, changes of the route action are copied back
+// to the TCP host by syncTcpHosts.
+type tcpHostRoute struct {
+	Host  *v1.TcpHost
+	Route *gwv1.Route
+}
+
+type gatewayBackend struct {
+	r            *RpcPlugin
+	pluginConfig *GlooEdgeTrafficRouting
+}
+
+type gatewayTarget struct {
+	gw         *gwv1.Gateway
+	original   *gwv1.Gateway
+	hostRoutes []tcpHostRoute
+	dsts       []destinationPair
+}
+
+func (b *gatewayBackend) discover(ctx context.Context, rollout *v1alpha1.Rollout) ([]routingTarget, error) {
+	gw, err := b.r.getGateway(ctx, rollout, b.pluginConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	original := &gwv1.Gateway{}
+	gw.DeepCopyInto(original)
+
+	hostRoutes := getTcpHostRoutes(gw)
+	dsts, err := b.r.getDestinationsInGateway(rollout, b.pluginConfig, gw, hostRoutes)
+	if err != nil {
+		return nil, err
+	}
+
+	return []routingTarget{&gatewayTarget{gw: gw, original: original, hostRoutes: hostRoutes, dsts: dsts}}, nil
+}
+
+func (b *gatewayBackend) patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return b.r.Client.Gateways().PatchGateway(ctx, obj.(*gwv1.Gateway), patch, opts...)
+}
+
+func (b *gatewayBackend) gvk() schema.GroupVersionKind {
+	return gwv1.GatewayGVK
+}
+
+func (b *gatewayBackend) managesUpstreams() bool {
+	return true
+}
+
+func (t *gatewayTarget) object() client.Object {
+	return t.gw
+}
+
+func (t *gatewayTarget) originalObject() client.Object {
+	return t.original
+}
+
+func (t *gatewayTarget) revert() {
+	t.original.Spec.DeepCopyInto(&t.gw.Spec)
+}
+
+func (t *gatewayTarget) namespace() string {
+	return t.gw.GetNamespace()
+}
+
+func (t *gatewayTarget) destinations() []destinationPair {
+	return t.dsts
+}
+
+// syncRoutes copies changed destinations back to TCP hosts, routes managed by the plugin (e.g. for sticky
+// sessions) aren't supported by TCP hosts
+func (t *gatewayTarget) syncRoutes(func(routes []*gwv1.Route) []*gwv1.Route) {
+	syncTcpHosts(t.hostRoutes)
+}
+
+func (t *gatewayTarget) changed() bool {
+	return !t.gw.Spec.Equal(&t.original.Spec)
+}
+
+func (r *RpcPlugin) getDestinationsInGateway(
+	rollout *v1alpha1.Rollout,
+	pluginConfig *GlooEdgeTrafficRouting,
+	gw *gwv1.Gateway,
+	hostRoutes []tcpHostRoute) (ret []destinationPair, err error) {
+
+	if len(hostRoutes) == 0 {
+		return nil, fmt.Errorf("no TCP hosts with destinations in Gateway %s/%s", gw.GetNamespace(), gw.GetName())
+	}
+
+	routes := make([]*gwv1.Route, len(hostRoutes))
+	for i := range hostRoutes {
+		routes[i] = hostRoutes[i].Route
+	}
+
+	for _, mapping := range getUpstreamMappings(rollout, pluginConfig) {
+		if len(routes) > 1 && len(mapping.Routes) == 0 {
+			return nil, fmt.Errorf("gateway %s/%s has multiple TCP hosts but canary config doesn't specify which routes to use",
+				gw.GetNamespace(), gw.GetName())
+		}
+
+		dsts := r.getMappedDestinationsInRoutes(routes, mapping)
+
+		if len(mapping.Routes) > 0 && len(dsts) != len(mapping.Routes) {
+			return nil, fmt.Errorf("some/all TCP hosts specified in canary rollout configuration do not have stable upstreams")
+		}
+
+		if len(dsts) == 0 {
+			return nil, fmt.Errorf("couldn't find stable upstreams in Gateway %s/%s, with TCP host names in %v",
+				gw.GetNamespace(), gw.GetName(), mapping.Routes)
+		}
+
+		ret = append(ret, dsts...)
+	}
+
+	return ret, nil
+}
+
+// getTcpHostRoutes returns TCP hosts with single or multi destinations of a TCP or hybrid Gateway
+func getTcpHostRoutes(gw *gwv1.Gateway) (ret []tcpHostRoute) {
+	hosts := gw.Spec.GetTcpGateway().GetTcpHosts()
+	for _, matched := range gw.Spec.GetHybridGateway().GetMatchedGateways() {
+		hosts = append(hosts, matched.GetTcpGateway().GetTcpHosts()...)
+	}
+
+	for _, host := range hosts {
+		routeAction := &v1.RouteAction{}
+		switch {
+		case host.GetDestination().GetSingle() != nil:
+			routeAction.Destination = &v1.RouteAction_Single{Single: host.GetDestination().GetSingle()}
+		case host.GetDestination().GetMulti() != nil:
+			routeAction.Destination = &v1.RouteAction_Multi{Multi: host.GetDestination().GetMulti()}
+		default:
+			continue
+		}
+
+		ret = append(ret, tcpHostRoute{
+			Host: host,
+			Route: &gwv1.Route{
+				Name:   host.GetName(),
+				Action: &gwv1.Route_RouteAction{RouteAction: routeAction},
+			},
+		})
+	}
+
+	return ret
+}
+
+// syncTcpHosts copies destinations of route actions back to TCP hosts
+func syncTcpHosts(hostRoutes []tcpHostRoute) {
+	for _, hostRoute := range hostRoutes {
+		routeAction := hostRoute.Route.GetRouteAction()
+		if routeAction.GetMulti() != nil {
+			hostRoute.Host.Destination.Destination = &v1.TcpHost_TcpAction_Multi{Multi: routeAction.GetMulti()}
+		} else {
+			hostRoute.Host.Destination.Destination = &v1.TcpHost_TcpAction_Single{Single: routeAction.GetSingle()}
+		}
+	}
+}
+
+func (r *RpcPlugin) getGateway(ctx context.Context, rollout *v1alpha1.Rollout, pluginConfig *GlooEdgeTrafficRouting) (*gwv1.Gateway, error) {
+	gwNamespace := pluginConfig.GatewaySelector.Namespace
+
+	if gwNamespace == "" {
+		r.LogCtx.Debugf("defaulting Gateway selector namespace to Rollout namespace %s for rollout %s", rollout.Namespace, rollout.Name)
+		gwNamespace = rollout.Namespace
+	}
+
+	if pluginConfig.GatewaySelector.Name == "" {
+		return nil, fmt.Errorf("must specify the name of the Gateway")
+	}
+
+	return r.Client.Gateways().GetGateway(ctx,
+		client.ObjectKey{Namespace: gwNamespace, Name: pluginConfig.GatewaySelector.Name})
+}
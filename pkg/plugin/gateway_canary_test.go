@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+)
+
+type GatewayCanarySuite struct {
+	suite.Suite
+	plugin        *RpcPlugin
+	ctrl          *gomock.Controller
+	ctx           context.Context
+	gwclient      *gloov1.MockClientset
+	glooclient    *gloomocks.MockClientset
+	usclient      *gloomocks.MockUpstreamClient
+	gatewayclient *gloov1.MockGatewayClient
+	loggerHook    *test.Hook
+}
+
+func (s *GatewayCanarySuite) SetupTest() {
+	s.ctx = context.TODO()
+	s.ctrl = gomock.NewController(s.T())
+	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	s.usclient = gloomocks.NewMockUpstreamClient(s.ctrl)
+	s.gatewayclient = gloov1.NewMockGatewayClient(s.ctrl)
+	var testLogger *logrus.Logger
+	testLogger, s.loggerHook = test.NewNullLogger()
+	s.plugin = &RpcPlugin{Client: gloo.NewGlooV1ClientSetFromClientsets(s.gwclient, s.glooclient), LogCtx: testLogger.WithContext(s.ctx)}
+}
+
+func TestGatewayCanarySuite(t *testing.T) {
+	suite.Run(t, new(GatewayCanarySuite))
+}
+
+func newTcpHost(name string, upstream string) *v1.TcpHost {
+	return &v1.TcpHost{
+		Name: name,
+		Destination: &v1.TcpHost_TcpAction{
+			Destination: &v1.TcpHost_TcpAction_Single{
+				Single: newUpstreamDestination(upstream, "", 0).GetDestination(),
+			},
+		},
+	}
+}
+
+func (s *GatewayCanarySuite) Test_getGateway_UsesRolloutNS() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	s.gatewayclient.EXPECT().GetGateway(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "rollout-ns", Name: "tcp"})).Times(1).Return(&gwv1.Gateway{}, nil)
+	s.gwclient.EXPECT().Gateways().Return(s.gatewayclient).Times(1)
+
+	_, err := s.plugin.getGateway(s.ctx, rollout, &GlooEdgeTrafficRouting{GatewaySelector: &DumbObjectSelector{Name: "tcp"}})
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *GatewayCanarySuite) Test_getTcpHostRoutes() {
+	gw := &gwv1.Gateway{
+		Spec: gwv1.GatewaySpec{
+			GatewayType: &gwv1.GatewaySpec_HybridGateway{
+				HybridGateway: &gwv1.HybridGateway{
+					MatchedGateways: []*gwv1.MatchedGateway{
+						{
+							GatewayType: &gwv1.MatchedGateway_TcpGateway{
+								TcpGateway: &gwv1.TcpGateway{
+									TcpHosts: []*v1.TcpHost{
+										newTcpHost("db", "stablesvc"),
+										{Name: "forward", Destination: &v1.TcpHost_TcpAction{
+											Destination: &v1.TcpHost_TcpAction_ForwardSniClusterName{},
+										}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	hostRoutes := getTcpHostRoutes(gw)
+
+	assert.Len(s.T(), hostRoutes, 1)
+	assert.Equal(s.T(), "db", hostRoutes[0].Route.GetName())
+	assert.Equal(s.T(), "stablesvc", hostRoutes[0].Route.GetRouteAction().GetSingle().GetUpstream().GetName())
+}
+
+func (s *GatewayCanarySuite) Test_getDestinationsInGateway_MultipleTcpHosts() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	gw := &gwv1.Gateway{
+		Spec: gwv1.GatewaySpec{
+			GatewayType: &gwv1.GatewaySpec_TcpGateway{
+				TcpGateway: &gwv1.TcpGateway{
+					TcpHosts: []*v1.TcpHost{newTcpHost("db", "stablesvc"), newTcpHost("cache", "othersvc")},
+				},
+			},
+		},
+	}
+	gw.SetNamespace("gloo-system")
+	gw.SetName("tcp")
+
+	_, err := s.plugin.getDestinationsInGateway(rollout, &GlooEdgeTrafficRouting{}, gw, getTcpHostRoutes(gw))
+	assert.EqualError(s.T(), err,
+		"gateway gloo-system/tcp has multiple TCP hosts but canary config doesn't specify which routes to use")
+
+	dsts, err := s.plugin.getDestinationsInGateway(rollout, &GlooEdgeTrafficRouting{Routes: []string{"db"}}, gw, getTcpHostRoutes(gw))
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), dsts, 1)
+	assert.Equal(s.T(), "stablesvc", dsts[0].Stable.GetDestination().GetUpstream().GetName())
+}
+
+func (s *GatewayCanarySuite) Test_handleCanary_UsingGateway() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	gw := &gwv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "gloo-system", Name: "tcp"},
+		Spec: gwv1.GatewaySpec{
+			GatewayType: &gwv1.GatewaySpec_TcpGateway{
+				TcpGateway: &gwv1.TcpGateway{TcpHosts: []*v1.TcpHost{newTcpHost("db", "stablesvc")}},
+			},
+		},
+	}
+
+	s.gatewayclient.EXPECT().GetGateway(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "tcp"})).Times(1).Return(gw, nil)
+	s.gatewayclient.EXPECT().PatchGateway(gomock.Any(), gomock.Any(), gomock.Any()).Times(1)
+	s.gwclient.EXPECT().Gateways().Return(s.gatewayclient).Times(2)
+	// used in ensureCanaryUpstreams() and verifyUpstreamsAccepted()
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "canarysvc"})).Times(2).Return(acceptedUpstream(), nil)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "stablesvc"})).Times(1).Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(3)
+
+	err := s.plugin.setWeight(s.ctx, rollout, 10, &GlooEdgeTrafficRouting{
+		GatewaySelector: &DumbObjectSelector{Namespace: "gloo-system", Name: "tcp"},
+	})
+
+	assert.NoError(s.T(), err)
+	dsts := gw.Spec.GetTcpGateway().GetTcpHosts()[0].GetDestination().GetMulti().GetDestinations()
+	assert.Len(s.T(), dsts, 2)
+	assert.Equal(s.T(), "stablesvc", dsts[0].GetDestination().GetUpstream().GetName())
+	assert.Equal(s.T(), uint32(90), dsts[0].GetWeight().GetValue())
+	assert.Equal(s.T(), "canarysvc", dsts[1].GetDestination().GetUpstream().GetName())
+	assert.Equal(s.T(), uint32(10), dsts[1].GetWeight().GetValue())
+}
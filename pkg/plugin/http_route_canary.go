@@ -0,0 +1,14 @@
+package plugin
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Gateway API HTTPRoutes (used by Gloo Gateway 2.x) are handled as unstructured objects. Rules are selected by
+// their names, the weights are set on Service backendRefs of the rules.
+var httpRouteKind = &unstructuredRouteKind{
+	Name:             "HTTPRoute",
+	GVK:              schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"},
+	RoutesPath:       []string{"spec", "rules"},
+	DestinationsPath: []string{"backendRefs"},
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+)
+
+type HTTPRouteCanarySuite struct {
+	suite.Suite
+	plugin     *RpcPlugin
+	ctrl       *gomock.Controller
+	ctx        context.Context
+	kubeclient client.Client
+	loggerHook *test.Hook
+}
+
+func (s *HTTPRouteCanarySuite) SetupTest() {
+	s.ctx = context.TODO()
+	s.ctrl = gomock.NewController(s.T())
+	s.kubeclient = fake.NewClientBuilder().WithScheme(runtime.NewScheme()).WithObjects(
+		newHTTPRoute("echo", map[string]interface{}{
+			"name": "api",
+			"backendRefs": []interface{}{
+				map[string]interface{}{"name": "stablesvc", "port": int64(80)},
+			},
+		}),
+	).Build()
+	var testLogger *logrus.Logger
+	testLogger, s.loggerHook = test.NewNullLogger()
+	s.plugin = &RpcPlugin{
+		Client: gloo.NewGlooV1ClientSetFromClients(gloov1.NewMockClientset(s.ctrl), gloomocks.NewMockClientset(s.ctrl), nil, s.kubeclient),
+		LogCtx: testLogger.WithContext(s.ctx),
+	}
+}
+
+func TestHTTPRouteCanarySuite(t *testing.T) {
+	suite.Run(t, new(HTTPRouteCanarySuite))
+}
+
+func newHTTPRoute(name string, rules ...interface{}) *unstructured.Unstructured {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteKind.GVK)
+	route.SetNamespace("rollout-ns")
+	route.SetName(name)
+	_ = unstructured.SetNestedSlice(route.Object, rules, "spec", "rules")
+	return route
+}
+
+func (s *HTTPRouteCanarySuite) Test_handleCanary_UsingHTTPRoutes() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	pluginConfig := &GlooEdgeTrafficRouting{
+		HTTPRouteSelector:       &DumbObjectSelector{Name: "echo"},
+		CanaryUpstreamNamespace: "canary-ns",
+	}
+
+	err := s.plugin.setWeight(s.ctx, rollout, 30, pluginConfig)
+
+	assert.NoError(s.T(), err)
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteKind.GVK)
+	assert.NoError(s.T(), s.kubeclient.Get(s.ctx, client.ObjectKey{Namespace: "rollout-ns", Name: "echo"}, route))
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	backendRefs, _, _ := unstructured.NestedSlice(rules[0].(map[string]interface{}), "backendRefs")
+	assert.Equal(s.T(), []interface{}{
+		map[string]interface{}{"name": "stablesvc", "port": int64(80), "weight": int64(70)},
+		map[string]interface{}{"name": "canarysvc", "namespace": "canary-ns", "port": int64(80), "weight": int64(30)},
+	}, backendRefs)
+}
+
+func (s *HTTPRouteCanarySuite) Test_handleCanary_UsingHTTPRoutes_ReturnsErrorWhenStableIsMissing() {
+	err := s.plugin.setWeight(s.ctx, newTestRollout("othersvc", "canarysvc"), 30, &GlooEdgeTrafficRouting{
+		HTTPRouteSelector: &DumbObjectSelector{Name: "echo"},
+	})
+
+	assert.EqualError(s.T(), err,
+		"couldn't find stable services in HTTPRoutes selected with Name: 'echo', Namespace: '', Labels: map[], with route names in []")
+}
+
+func (s *HTTPRouteCanarySuite) Test_handleCanary_UsingHTTPRoutes_SkipsUnchangedRoutes() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	pluginConfig := &GlooEdgeTrafficRouting{HTTPRouteSelector: &DumbObjectSelector{Name: "echo"}}
+	key := client.ObjectKey{Namespace: "rollout-ns", Name: "echo"}
+
+	assert.NoError(s.T(), s.plugin.setWeight(s.ctx, rollout, 30, pluginConfig))
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteKind.GVK)
+	assert.NoError(s.T(), s.kubeclient.Get(s.ctx, key, route))
+	resourceVersion := route.GetResourceVersion()
+
+	assert.NoError(s.T(), s.plugin.setWeight(s.ctx, rollout, 30, pluginConfig))
+	assert.NoError(s.T(), s.kubeclient.Get(s.ctx, key, route))
+	assert.Equal(s.T(), resourceVersion, route.GetResourceVersion())
+}
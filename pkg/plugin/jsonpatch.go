@@ -0,0 +1,350 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// jsonPatchOperation is an RFC 6902 JSON Patch operation
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// jsonPatch computes JSON Patch operations that change the original object into the object being patched. Unlike
+// a merge patch, which replaces whole lists, the operations only touch the fields that were changed and the list
+// items that were added or removed. Replaced and removed values are guarded by `test` operations, so the patch
+// fails instead of overwriting values changed concurrently, while unrelated changes made at the same time survive.
+// Array items along the paths of changes are guarded by `test` operations on their identities, e.g. the names of
+// routes and the references of destinations, so that changes aren't applied to other items when items were
+// inserted or removed concurrently.
+type jsonPatch struct {
+	original client.Object
+}
+
+// jsonPatchFrom returns a JSON Patch computed from the original object, see jsonPatch
+func jsonPatchFrom(original client.Object) client.Patch {
+	return &jsonPatch{original: original}
+}
+
+func (p *jsonPatch) Type() types.PatchType {
+	return types.JSONPatchType
+}
+
+func (p *jsonPatch) Data(obj client.Object) ([]byte, error) {
+	var original, modified interface{}
+	if err := roundTripJSON(p.original, &original); err != nil {
+		return nil, err
+	}
+	if err := roundTripJSON(obj, &modified); err != nil {
+		return nil, err
+	}
+
+	ops, err := diffJSON(nil, "", original, modified)
+	if err != nil {
+		return nil, err
+	}
+	if ops == nil {
+		ops = []jsonPatchOperation{}
+	}
+
+	return json.Marshal(ops)
+}
+
+func roundTripJSON(obj interface{}, out *interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// isFailedPatchTest returns true when a JSON Patch couldn't be applied, e.g. because a `test` operation failed, i.e.
+// when the patched values were changed concurrently. The API server rejects such patches with 422 Unprocessable
+// Entity, without the name of the resource and the causes returned for resources that fail validation.
+func isFailedPatchTest(err error) bool {
+	if !k8serrors.IsInvalid(err) {
+		return false
+	}
+	var status k8serrors.APIStatus
+	if !errors.As(err, &status) {
+		return false
+	}
+	details := status.Status().Details
+	return details == nil || (details.Name == "" && len(details.Causes) == 0)
+}
+
+// identityKeys are the keys of fields that identify array items, e.g. the names of routes and the references
+// of Gloo Edge, Gloo Platform and Gateway API destinations
+var identityKeys = []string{"name", "namespace", "destination", "ref"}
+
+// identityTestOps guards an array item changed in place with `test` operations on its identity fields that
+// aren't changed
+func identityTestOps(ops []jsonPatchOperation, path string, original, modified interface{}) ([]jsonPatchOperation, error) {
+	o, ok := original.(map[string]interface{})
+	if !ok {
+		return ops, nil
+	}
+	m, ok := modified.(map[string]interface{})
+	if !ok {
+		return ops, nil
+	}
+
+	var err error
+	for _, key := range identityKeys {
+		value, found := o[key]
+		if !found || !reflect.DeepEqual(value, m[key]) {
+			continue
+		}
+		if ops, err = testOp(ops, path+"/"+escapeJSONPointer(key), value); err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// sameIdentity returns true when array items have the same identity fields, see identityKeys. Items that aren't
+// objects have no identity.
+func sameIdentity(original, modified interface{}) bool {
+	o, ok := original.(map[string]interface{})
+	if !ok {
+		return true
+	}
+	m, ok := modified.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, key := range identityKeys {
+		if !reflect.DeepEqual(o[key], m[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// diffJSONItem diffs an array item kept in the array, guarding it with identityTestOps when it's changed
+func diffJSONItem(ops []jsonPatchOperation, path string, original, modified interface{}) ([]jsonPatchOperation, error) {
+	if reflect.DeepEqual(original, modified) {
+		return ops, nil
+	}
+	ops, err := identityTestOps(ops, path, original, modified)
+	if err != nil {
+		return nil, err
+	}
+	return diffJSON(ops, path, original, modified)
+}
+
+func diffJSON(ops []jsonPatchOperation, path string, original, modified interface{}) ([]jsonPatchOperation, error) {
+	if reflect.DeepEqual(original, modified) {
+		return ops, nil
+	}
+
+	switch o := original.(type) {
+	case map[string]interface{}:
+		if m, ok := modified.(map[string]interface{}); ok {
+			return diffJSONObjects(ops, path, o, m)
+		}
+	case []interface{}:
+		if m, ok := modified.([]interface{}); ok {
+			return diffJSONArrays(ops, path, o, m)
+		}
+	}
+
+	return replaceOps(ops, path, original, modified)
+}
+
+func diffJSONObjects(ops []jsonPatchOperation, path string, original, modified map[string]interface{}) ([]jsonPatchOperation, error) {
+	var err error
+	for _, key := range sortedKeys(original) {
+		if _, found := modified[key]; !found {
+			if ops, err = removeOps(ops, path+"/"+escapeJSONPointer(key), original[key]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, key := range sortedKeys(original) {
+		if m, found := modified[key]; found {
+			if ops, err = diffJSON(ops, path+"/"+escapeJSONPointer(key), original[key], m); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, key := range sortedKeys(modified) {
+		if _, found := original[key]; !found {
+			if ops, err = addOp(ops, path+"/"+escapeJSONPointer(key), modified[key]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ops, nil
+}
+
+// diffJSONArrays matches items of arrays by their names when all items have unique names (e.g. routes, some of
+// which are inserted by the plugin), otherwise by their positions. When items without names were inserted or
+// removed anywhere but at the end, e.g. sticky session routes inserted in front of routes without names, items
+// at the same positions are different items, so the array is replaced as a whole.
+func diffJSONArrays(ops []jsonPatchOperation, path string, original, modified []interface{}) ([]jsonPatchOperation, error) {
+	originalNames, originalNamed := itemNames(original)
+	modifiedNames, modifiedNamed := itemNames(modified)
+	if originalNamed && modifiedNamed {
+		return diffNamedJSONArrays(ops, path, original, modified, originalNames, modifiedNames)
+	}
+
+	if len(original) != len(modified) {
+		for i := 0; i < len(original) && i < len(modified); i++ {
+			if !sameIdentity(original[i], modified[i]) {
+				return replaceOps(ops, path, original, modified)
+			}
+		}
+	}
+
+	var err error
+	for i := len(original) - 1; i >= len(modified); i-- {
+		if ops, err = removeOps(ops, fmt.Sprintf("%s/%d", path, i), original[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < len(original) && i < len(modified); i++ {
+		if ops, err = diffJSONItem(ops, fmt.Sprintf("%s/%d", path, i), original[i], modified[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := len(original); i < len(modified); i++ {
+		if ops, err = addOp(ops, fmt.Sprintf("%s/%d", path, i), modified[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return ops, nil
+}
+
+func diffNamedJSONArrays(
+	ops []jsonPatchOperation,
+	path string,
+	original, modified []interface{},
+	originalNames, modifiedNames []string) ([]jsonPatchOperation, error) {
+
+	modifiedIndex := make(map[string]int, len(modifiedNames))
+	for i, name := range modifiedNames {
+		modifiedIndex[name] = i
+	}
+
+	// items kept in the array must stay in the same order, they're replaced as a whole otherwise
+	var kept []int
+	last := -1
+	for i, name := range originalNames {
+		j, found := modifiedIndex[name]
+		if !found {
+			continue
+		}
+		if j < last {
+			return replaceOps(ops, path, original, modified)
+		}
+		last = j
+		kept = append(kept, i)
+	}
+
+	var err error
+	for i := len(original) - 1; i >= 0; i-- {
+		if _, found := modifiedIndex[originalNames[i]]; !found {
+			if ops, err = removeOps(ops, fmt.Sprintf("%s/%d", path, i), original[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	k := 0
+	for j := range modified {
+		if k < len(kept) && originalNames[kept[k]] == modifiedNames[j] {
+			if ops, err = diffJSONItem(ops, fmt.Sprintf("%s/%d", path, j), original[kept[k]], modified[j]); err != nil {
+				return nil, err
+			}
+			k++
+			continue
+		}
+		if ops, err = addOp(ops, fmt.Sprintf("%s/%d", path, j), modified[j]); err != nil {
+			return nil, err
+		}
+	}
+
+	return ops, nil
+}
+
+// itemNames returns the names of array items, and whether all the items are objects with unique names
+func itemNames(items []interface{}) ([]string, bool) {
+	names := make([]string, len(items))
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := obj["name"].(string)
+		if !ok || name == "" || seen[name] {
+			return nil, false
+		}
+		seen[name] = true
+		names[i] = name
+	}
+	return names, true
+}
+
+func replaceOps(ops []jsonPatchOperation, path string, original, modified interface{}) ([]jsonPatchOperation, error) {
+	ops, err := testOp(ops, path, original)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, jsonPatchOperation{Op: "replace", Path: path, Value: value}), nil
+}
+
+func removeOps(ops []jsonPatchOperation, path string, original interface{}) ([]jsonPatchOperation, error) {
+	ops, err := testOp(ops, path, original)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, jsonPatchOperation{Op: "remove", Path: path}), nil
+}
+
+func testOp(ops []jsonPatchOperation, path string, original interface{}) ([]jsonPatchOperation, error) {
+	value, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, jsonPatchOperation{Op: "test", Path: path, Value: value}), nil
+}
+
+func addOp(ops []jsonPatchOperation, path string, modified interface{}) ([]jsonPatchOperation, error) {
+	value, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, jsonPatchOperation{Op: "add", Path: path, Value: value}), nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeJSONPointer escapes a reference token of a JSON Pointer (RFC 6901)
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
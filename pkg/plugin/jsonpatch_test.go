@@ -0,0 +1,277 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func Test_jsonPatch_TouchesChangedFieldsOnly(t *testing.T) {
+	original := &gwv1.VirtualService{
+		Spec: gwv1.VirtualServiceSpec{
+			VirtualHost: &gwv1.VirtualHost{
+				Routes: []*gwv1.Route{
+					newSingleUpstreamRoute("other", "othersvc"),
+					{
+						Name: "echo",
+						Action: &gwv1.Route_RouteAction{
+							RouteAction: &v1.RouteAction{
+								Destination: &v1.RouteAction_Multi{
+									Multi: &v1.MultiDestination{Destinations: []*v1.WeightedDestination{
+										newUpstreamDestination("stablesvc", "", 100),
+									}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	modified := original.DeepCopy()
+	multi := modified.Spec.GetVirtualHost().GetRoutes()[1].GetRouteAction().GetMulti()
+	multi.GetDestinations()[0].Weight = wrapperspb.UInt32(90)
+	multi.Destinations = append(multi.Destinations, newUpstreamDestination("canarysvc", "", 10))
+	sticky := newSingleUpstreamRoute(ManagedRoutePrefix+"echo", "canarysvc")
+	modified.Spec.VirtualHost.Routes = append([]*gwv1.Route{modified.Spec.GetVirtualHost().GetRoutes()[0], sticky},
+		modified.Spec.GetVirtualHost().GetRoutes()[1:]...)
+
+	data, err := jsonPatchFrom(original).Data(modified)
+
+	require.NoError(t, err)
+	var ops []jsonPatchOperation
+	require.NoError(t, json.Unmarshal(data, &ops))
+	paths := make([]string, len(ops))
+	for i, op := range ops {
+		paths[i] = op.Op + " " + op.Path
+	}
+	assert.Equal(t, []string{
+		"add /spec/virtualHost/routes/1",
+		"test /spec/virtualHost/routes/2/name",
+		"test /spec/virtualHost/routes/2/routeAction/multi/destinations/0/destination",
+		"test /spec/virtualHost/routes/2/routeAction/multi/destinations/0/weight",
+		"replace /spec/virtualHost/routes/2/routeAction/multi/destinations/0/weight",
+		"add /spec/virtualHost/routes/2/routeAction/multi/destinations/1",
+	}, paths)
+	assert.JSONEq(t, `"echo"`, string(ops[1].Value))
+	assert.JSONEq(t, `{"upstream": {"name": "stablesvc"}}`, string(ops[2].Value))
+	assert.JSONEq(t, "100", string(ops[3].Value))
+	assert.JSONEq(t, "90", string(ops[4].Value))
+}
+
+func Test_jsonPatch_RemovesGuardedItems(t *testing.T) {
+	original := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"list": []interface{}{"a", "b", "c"}},
+	}}
+	modified := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"list": []interface{}{"a"}},
+	}}
+
+	data, err := jsonPatchFrom(original).Data(modified)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"op": "test", "path": "/spec/list/2", "value": "c"},
+		{"op": "remove", "path": "/spec/list/2"},
+		{"op": "test", "path": "/spec/list/1", "value": "b"},
+		{"op": "remove", "path": "/spec/list/1"}
+	]`, string(data))
+}
+
+func Test_jsonPatch_KeepsConcurrentChanges(t *testing.T) {
+	ctx := context.TODO()
+	kubeClient := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).WithObjects(
+		newHTTPRoute("echo",
+			map[string]interface{}{"name": "api", "backendRefs": []interface{}{map[string]interface{}{"name": "stablesvc"}}},
+			map[string]interface{}{"name": "web", "backendRefs": []interface{}{map[string]interface{}{"name": "websvc"}}},
+		),
+	).Build()
+	get := func() *unstructured.Unstructured {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(httpRouteKind.GVK)
+		require.NoError(t, kubeClient.Get(ctx, client.ObjectKey{Namespace: "rollout-ns", Name: "echo"}, route))
+		return route
+	}
+
+	route := get()
+	original := route.DeepCopy()
+	setBackendRefField(t, route, 0, "weight", 90)
+	concurrent := get()
+	setBackendRefField(t, concurrent, 1, "port", 8080)
+	require.NoError(t, kubeClient.Update(ctx, concurrent))
+
+	assert.NoError(t, kubeClient.Patch(ctx, route, jsonPatchFrom(original)))
+
+	rules, _, _ := unstructured.NestedSlice(get().Object, "spec", "rules")
+	assert.Equal(t, map[string]interface{}{"name": "stablesvc", "weight": int64(90)},
+		rules[0].(map[string]interface{})["backendRefs"].([]interface{})[0])
+	assert.Equal(t, map[string]interface{}{"name": "websvc", "port": int64(8080)},
+		rules[1].(map[string]interface{})["backendRefs"].([]interface{})[0])
+}
+
+func Test_jsonPatch_FailsWhenGuardedValueChanged(t *testing.T) {
+	ctx := context.TODO()
+	kubeClient := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).WithObjects(
+		newHTTPRoute("echo", map[string]interface{}{
+			"name": "api", "backendRefs": []interface{}{map[string]interface{}{"name": "stablesvc", "weight": int64(100)}},
+		}),
+	).Build()
+	get := func() *unstructured.Unstructured {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(httpRouteKind.GVK)
+		require.NoError(t, kubeClient.Get(ctx, client.ObjectKey{Namespace: "rollout-ns", Name: "echo"}, route))
+		return route
+	}
+
+	route := get()
+	original := route.DeepCopy()
+	setBackendRefField(t, route, 0, "weight", 90)
+	concurrent := get()
+	setBackendRefField(t, concurrent, 0, "weight", 50)
+	require.NoError(t, kubeClient.Update(ctx, concurrent))
+
+	err := kubeClient.Patch(ctx, route, jsonPatchFrom(original))
+
+	assert.ErrorContains(t, err, "testing value /spec/rules/0/backendRefs/0/weight failed")
+	rules, _, _ := unstructured.NestedSlice(get().Object, "spec", "rules")
+	assert.Equal(t, int64(50), rules[0].(map[string]interface{})["backendRefs"].([]interface{})[0].(map[string]interface{})["weight"])
+}
+
+func Test_jsonPatch_FailsWhenItemsWereInsertedConcurrently(t *testing.T) {
+	ctx := context.TODO()
+	stable := newPlatformDestination("stablesvc")
+	stable["weight"] = int64(100)
+	kubeClient := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).WithObjects(
+		newPlatformRouteTable("rt1", nil, newPlatformRoute("route1", stable)),
+	).Build()
+	get := func() *unstructured.Unstructured {
+		rt := &unstructured.Unstructured{}
+		rt.SetGroupVersionKind(platformRouteTableKind.GVK)
+		require.NoError(t, kubeClient.Get(ctx, client.ObjectKey{Namespace: "rollout-ns", Name: "rt1"}, rt))
+		return rt
+	}
+	setDestinations := func(rt *unstructured.Unstructured, dsts ...interface{}) {
+		routes, _, _ := unstructured.NestedSlice(rt.Object, "spec", "http")
+		require.NoError(t, unstructured.SetNestedSlice(routes[0].(map[string]interface{}), dsts, "forwardTo", "destinations"))
+		require.NoError(t, unstructured.SetNestedSlice(rt.Object, routes, "spec", "http"))
+	}
+
+	rt := get()
+	original := rt.DeepCopy()
+	changed := runtime.DeepCopyJSON(stable)
+	changed["weight"] = int64(90)
+	setDestinations(rt, changed)
+	// a destination with the same weight is inserted before the stable one
+	concurrent := get()
+	other := newPlatformDestination("othersvc")
+	other["weight"] = int64(100)
+	setDestinations(concurrent, other, stable)
+	require.NoError(t, kubeClient.Update(ctx, concurrent))
+
+	err := kubeClient.Patch(ctx, rt, jsonPatchFrom(original))
+
+	assert.ErrorContains(t, err, "testing value /spec/http/0/forwardTo/destinations/0/ref failed")
+}
+
+func Test_jsonPatch_ReplacesUnnamedRoutesShiftedByStickyRoutes(t *testing.T) {
+	route := &gwv1.Route{
+		Action: &gwv1.Route_RouteAction{
+			RouteAction: &v1.RouteAction{
+				Destination: &v1.RouteAction_Multi{
+					Multi: &v1.MultiDestination{Destinations: []*v1.WeightedDestination{
+						newUpstreamDestination("stablesvc", "", 100),
+					}},
+				},
+			},
+		},
+	}
+	other := newSingleUpstreamRoute("", "othersvc")
+	original := &gwv1.VirtualService{
+		Spec: gwv1.VirtualServiceSpec{
+			VirtualHost: &gwv1.VirtualHost{Routes: []*gwv1.Route{route, other}},
+		},
+	}
+	modified := original.DeepCopy()
+	routeAction := modified.Spec.GetVirtualHost().GetRoutes()[0].GetRouteAction()
+	multi := routeAction.GetMulti()
+	multi.GetDestinations()[0].Weight = wrapperspb.UInt32(70)
+	multi.Destinations = append(multi.Destinations, newUpstreamDestination("canarysvc", "", 30))
+	dsts := []destinationPair{{DestinationsParent: routeAction, Stable: multi.GetDestinations()[0], Canary: multi.GetDestinations()[1]}}
+	modified.Spec.VirtualHost.Routes = (&RpcPlugin{}).syncStickyRoutes(modified.Spec.GetVirtualHost().GetRoutes(), dsts,
+		newTestRollout("stablesvc", "canarysvc"), 30, &GlooEdgeTrafficRouting{StickySessions: &StickySessions{}})
+	require.Len(t, modified.Spec.GetVirtualHost().GetRoutes(), 3)
+
+	data, err := jsonPatchFrom(original).Data(modified)
+
+	require.NoError(t, err)
+	var ops []jsonPatchOperation
+	require.NoError(t, json.Unmarshal(data, &ops))
+	paths := make([]string, len(ops))
+	for i, op := range ops {
+		paths[i] = op.Op + " " + op.Path
+	}
+	// routes without names can't be told apart by their positions once a sticky route is inserted before them
+	assert.Equal(t, []string{
+		"test /spec/virtualHost/routes",
+		"replace /spec/virtualHost/routes",
+	}, paths)
+	var routes []interface{}
+	require.NoError(t, json.Unmarshal(ops[1].Value, &routes))
+	assert.Len(t, routes, 3)
+}
+
+func Test_isFailedPatchTest(t *testing.T) {
+	gr := schema.GroupResource{Group: "gateway.solo.io", Resource: "virtualservices"}
+	for name, tc := range map[string]struct {
+		err      error
+		expected bool
+	}{
+		"patch that can't be applied": {
+			err:      k8serrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "PATCH", schema.GroupResource{}, "", "", 0, false),
+			expected: true,
+		},
+		"wrapped patch that can't be applied": {
+			err: fmt.Errorf("VirtualService testns/testvs: %w",
+				k8serrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "PATCH", schema.GroupResource{}, "", "", 0, false)),
+			expected: true,
+		},
+		"invalid resource": {
+			err: k8serrors.NewInvalid(schema.GroupKind{Group: "gateway.solo.io", Kind: "VirtualService"}, "testvs",
+				field.ErrorList{field.Invalid(field.NewPath("spec"), nil, "invalid route")}),
+		},
+		"message of failed test without status": {
+			err: errors.New("testing value /spec/weight failed: test failed"),
+		},
+		"conflict": {
+			err: k8serrors.NewConflict(gr, "testvs", errors.New("the object has been modified")),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isFailedPatchTest(tc.err))
+		})
+	}
+}
+
+// setBackendRefField sets a field of the first backendRef of a rule of an HTTPRoute
+func setBackendRefField(t *testing.T, route *unstructured.Unstructured, rule int, field string, value int64) {
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	rules[rule].(map[string]interface{})["backendRefs"].([]interface{})[0].(map[string]interface{})[field] = value
+	require.NoError(t, unstructured.SetNestedSlice(route.Object, rules, "spec", "rules"))
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	fedgwv1 "github.com/solo-io/solo-apis/pkg/api/fed.gateway.solo.io/v1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// keyedMutex is a set of read-write locks created on demand and dropped when they're not used. The zero value is
+// ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refCountedLock
+}
+
+type refCountedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+func (m *keyedMutex) acquire(key string) *refCountedLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks == nil {
+		m.locks = make(map[string]*refCountedLock)
+	}
+	l, found := m.locks[key]
+	if !found {
+		l = &refCountedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (m *keyedMutex) release(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, key)
+	}
+}
+
+// lock locks the key exclusively and returns the function unlocking it
+func (m *keyedMutex) lock(key string) func() {
+	l := m.acquire(key)
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.release(key)
+	}
+}
+
+// rLock locks the key shared and returns the function unlocking it
+func (m *keyedMutex) rLock(key string) func() {
+	l := m.acquire(key)
+	l.RLock()
+	return func() {
+		l.RUnlock()
+		m.release(key)
+	}
+}
+
+// lockRoutingResources serializes updates of the routing resources selected in plugin configuration with other
+// operations of the plugin, e.g. updates of other Rollouts routing via the same VirtualService. A resource selected
+// by name is locked with the namespace/kind/name key. Resources selected by labels aren't known before they're
+// read, so all the resources of the kind in the namespace are locked. Returns the function releasing the locks.
+func (r *RpcPlugin) lockRoutingResources(rollout *v1alpha1.Rollout, pluginConfig *GlooEdgeTrafficRouting) func() {
+	gvk, selector := routingSelector(pluginConfig)
+	if selector == nil {
+		return func() {}
+	}
+
+	namespace := selector.Namespace
+	if namespace == "" {
+		namespace = rollout.Namespace
+	}
+	kindKey := fmt.Sprintf("%s/%s", namespace, gvk.GroupKind())
+
+	if selector.Name == "" {
+		return r.locks.lock(kindKey)
+	}
+
+	// resources selected by name share the lock of their kind with each other, and exclude selections by labels
+	unlockKind := r.locks.rLock(kindKey)
+	unlockName := r.locks.lock(fmt.Sprintf("%s/%s", kindKey, selector.Name))
+	return func() {
+		unlockName()
+		unlockKind()
+	}
+}
+
+// routingSelector returns the kind and the selector of the routing resources selected in plugin configuration
+func routingSelector(pluginConfig *GlooEdgeTrafficRouting) (schema.GroupVersionKind, *DumbObjectSelector) {
+	switch {
+	case pluginConfig.VirtualServiceSelector != nil:
+		return gwv1.VirtualServiceGVK, pluginConfig.VirtualServiceSelector
+	case pluginConfig.GatewaySelector != nil:
+		return gwv1.GatewayGVK, pluginConfig.GatewaySelector
+	case pluginConfig.FederatedVirtualServiceSelector != nil:
+		return fedgwv1.FederatedVirtualServiceGVK, pluginConfig.FederatedVirtualServiceSelector
+	case pluginConfig.FederatedRouteTableSelector != nil:
+		return fedgwv1.FederatedRouteTableGVK, pluginConfig.FederatedRouteTableSelector
+	case pluginConfig.PlatformRouteTableSelector != nil:
+		return platformRouteTableKind.GVK, pluginConfig.PlatformRouteTableSelector
+	case pluginConfig.HTTPRouteSelector != nil:
+		return httpRouteKind.GVK, pluginConfig.HTTPRouteSelector
+	default:
+		return gwv1.RouteTableGVK, pluginConfig.RouteTableSelector
+	}
+}
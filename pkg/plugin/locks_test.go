@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lockedWithin returns true when lockRoutingResources acquires the locks before the timeout
+func lockedWithin(r *RpcPlugin, pluginConfig *GlooEdgeTrafficRouting, timeout time.Duration) (bool, func()) {
+	unlocked := make(chan func(), 1)
+	go func() {
+		unlocked <- r.lockRoutingResources(newTestRollout("stablesvc", "canarysvc"), pluginConfig)
+	}()
+	select {
+	case unlock := <-unlocked:
+		return true, unlock
+	case <-time.After(timeout):
+		return false, func() { (<-unlocked)() }
+	}
+}
+
+func Test_lockRoutingResources_SerializesSameResource(t *testing.T) {
+	r := &RpcPlugin{}
+	pluginConfig := &GlooEdgeTrafficRouting{VirtualServiceSelector: &DumbObjectSelector{Name: "vs"}}
+
+	unlock := r.lockRoutingResources(newTestRollout("stablesvc", "canarysvc"), pluginConfig)
+	locked, unlockOther := lockedWithin(r, pluginConfig, 50*time.Millisecond)
+	assert.False(t, locked)
+
+	unlock()
+	unlockOther()
+	assert.Empty(t, r.locks.locks)
+}
+
+func Test_lockRoutingResources_DoesNotSerializeOtherResources(t *testing.T) {
+	r := &RpcPlugin{}
+
+	unlock := r.lockRoutingResources(newTestRollout("stablesvc", "canarysvc"),
+		&GlooEdgeTrafficRouting{VirtualServiceSelector: &DumbObjectSelector{Name: "vs"}})
+	defer unlock()
+
+	for _, pluginConfig := range []*GlooEdgeTrafficRouting{
+		{VirtualServiceSelector: &DumbObjectSelector{Name: "other"}},
+		{VirtualServiceSelector: &DumbObjectSelector{Name: "vs", Namespace: "otherns"}},
+		{RouteTableSelector: &DumbObjectSelector{Name: "vs"}},
+	} {
+		locked, unlockOther := lockedWithin(r, pluginConfig, time.Second)
+		assert.True(t, locked)
+		unlockOther()
+	}
+}
+
+func Test_lockRoutingResources_SerializesLabelSelectionWithResourcesOfKind(t *testing.T) {
+	r := &RpcPlugin{}
+
+	unlock := r.lockRoutingResources(newTestRollout("stablesvc", "canarysvc"),
+		&GlooEdgeTrafficRouting{RouteTableSelector: &DumbObjectSelector{Name: "rt"}})
+	locked, unlockOther := lockedWithin(r,
+		&GlooEdgeTrafficRouting{RouteTableSelector: &DumbObjectSelector{Labels: map[string]string{"app": "echo"}}}, 50*time.Millisecond)
+	assert.False(t, locked)
+
+	unlock()
+	unlockOther()
+	assert.Empty(t, r.locks.locks)
+}
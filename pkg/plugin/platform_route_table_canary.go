@@ -0,0 +1,14 @@
+package plugin
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Gloo Platform RouteTables are handled as unstructured objects, there are no typed clients for them in solo-apis
+var platformRouteTableKind = &unstructuredRouteKind{
+	Name:             "Gloo Platform RouteTable",
+	GVK:              schema.GroupVersionKind{Group: "networking.gloo.solo.io", Version: "v2", Kind: "RouteTable"},
+	RoutesPath:       []string{"spec", "http"},
+	DestinationsPath: []string{"forwardTo", "destinations"},
+	RefPath:          []string{"ref"},
+}
@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+)
+
+type PlatformRouteTableCanarySuite struct {
+	suite.Suite
+	ctrl       *gomock.Controller
+	ctx        context.Context
+	gwclient   *gloov1.MockClientset
+	glooclient *gloomocks.MockClientset
+	loggerHook *test.Hook
+	logger     *logrus.Logger
+}
+
+func (s *PlatformRouteTableCanarySuite) SetupTest() {
+	s.ctx = context.TODO()
+	s.ctrl = gomock.NewController(s.T())
+	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	s.logger, s.loggerHook = test.NewNullLogger()
+}
+
+func TestPlatformRouteTableCanarySuite(t *testing.T) {
+	suite.Run(t, new(PlatformRouteTableCanarySuite))
+}
+
+func (s *PlatformRouteTableCanarySuite) newPlugin(objs ...client.Object) (*RpcPlugin, client.Client) {
+	kubeClient := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).WithObjects(objs...).Build()
+	return &RpcPlugin{
+		Client: gloo.NewGlooV1ClientSetFromClients(s.gwclient, s.glooclient, nil, kubeClient),
+		LogCtx: s.logger.WithContext(s.ctx),
+	}, kubeClient
+}
+
+func newPlatformRouteTable(name string, labels map[string]string, routes ...interface{}) *unstructured.Unstructured {
+	rt := &unstructured.Unstructured{}
+	rt.SetGroupVersionKind(platformRouteTableKind.GVK)
+	rt.SetNamespace("rollout-ns")
+	rt.SetName(name)
+	rt.SetLabels(labels)
+	_ = unstructured.SetNestedSlice(rt.Object, routes, "spec", "http")
+	return rt
+}
+
+func newPlatformRoute(name string, dsts ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":      name,
+		"forwardTo": map[string]interface{}{"destinations": dsts},
+	}
+}
+
+func newPlatformDestination(service string) map[string]interface{} {
+	return map[string]interface{}{
+		"ref":  map[string]interface{}{"name": service, "namespace": "rollout-ns"},
+		"port": map[string]interface{}{"number": int64(8080)},
+	}
+}
+
+func (s *PlatformRouteTableCanarySuite) getDestinations(c client.Client, name string, route int) []interface{} {
+	rt := &unstructured.Unstructured{}
+	rt.SetGroupVersionKind(platformRouteTableKind.GVK)
+	assert.NoError(s.T(), c.Get(s.ctx, client.ObjectKey{Namespace: "rollout-ns", Name: name}, rt))
+	routes, _, _ := unstructured.NestedSlice(rt.Object, "spec", "http")
+	dsts, _, _ := unstructured.NestedSlice(routes[route].(map[string]interface{}), "forwardTo", "destinations")
+	return dsts
+}
+
+func (s *PlatformRouteTableCanarySuite) Test_handleCanary_UsingPlatformRouteTables() {
+	plugin, kubeClient := s.newPlugin(
+		newPlatformRouteTable("rt1", map[string]string{"app": "echo"}, newPlatformRoute("route1", newPlatformDestination("stablesvc"))),
+		newPlatformRouteTable("rt2", map[string]string{"app": "echo"},
+			newPlatformRoute("route1", newPlatformDestination("othersvc")),
+			newPlatformRoute("route2", newPlatformDestination("stablesvc"))),
+		newPlatformRouteTable("rt3", map[string]string{"app": "other"}, newPlatformRoute("route1", newPlatformDestination("stablesvc"))),
+	)
+
+	err := plugin.setWeight(s.ctx, newTestRollout("stablesvc", "canarysvc"), 10, &GlooEdgeTrafficRouting{
+		PlatformRouteTableSelector: &DumbObjectSelector{Labels: map[string]string{"app": "echo"}},
+		Routes:                     []string{"route1", "route2"},
+	})
+
+	assert.NoError(s.T(), err)
+	for _, dsts := range [][]interface{}{s.getDestinations(kubeClient, "rt1", 0), s.getDestinations(kubeClient, "rt2", 1)} {
+		assert.Len(s.T(), dsts, 2)
+		stable, canary := dsts[0].(map[string]interface{}), dsts[1].(map[string]interface{})
+		assert.Equal(s.T(), int64(90), stable["weight"])
+		assert.Equal(s.T(), int64(10), canary["weight"])
+		name, _, _ := unstructured.NestedString(canary, "ref", "name")
+		assert.Equal(s.T(), "canarysvc", name)
+		port, _, _ := unstructured.NestedInt64(canary, "port", "number")
+		assert.Equal(s.T(), int64(8080), port)
+	}
+	// routes without stable destinations and RouteTables that aren't selected aren't changed
+	assert.Len(s.T(), s.getDestinations(kubeClient, "rt2", 0), 1)
+	assert.Len(s.T(), s.getDestinations(kubeClient, "rt3", 0), 1)
+
+	// existing canary destinations are reused
+	err = plugin.setWeight(s.ctx, newTestRollout("stablesvc", "canarysvc"), 50, &GlooEdgeTrafficRouting{
+		PlatformRouteTableSelector: &DumbObjectSelector{Name: "rt1"},
+	})
+
+	assert.NoError(s.T(), err)
+	dsts := s.getDestinations(kubeClient, "rt1", 0)
+	assert.Len(s.T(), dsts, 2)
+	assert.Equal(s.T(), int64(50), dsts[1].(map[string]interface{})["weight"])
+}
+
+func (s *PlatformRouteTableCanarySuite) Test_handleCanary_UsingPlatformRouteTables_ReturnsErrors() {
+	plugin, _ := s.newPlugin(
+		newPlatformRouteTable("rt1", nil,
+			newPlatformRoute("route1", newPlatformDestination("stablesvc")),
+			newPlatformRoute("route2", newPlatformDestination("stablesvc"))),
+	)
+	rollout := newTestRollout("stablesvc", "canarysvc")
+
+	err := plugin.setWeight(s.ctx, rollout, 10, &GlooEdgeTrafficRouting{
+		PlatformRouteTableSelector: &DumbObjectSelector{},
+	})
+	assert.EqualError(s.T(), err, "name or labels field must be set in Gloo Platform RouteTable selector")
+
+	err = plugin.setWeight(s.ctx, rollout, 10, &GlooEdgeTrafficRouting{
+		PlatformRouteTableSelector: &DumbObjectSelector{Name: "rt1"},
+	})
+	assert.EqualError(s.T(), err,
+		"Gloo Platform RouteTable rollout-ns/rt1 has multiple routes but canary config doesn't specify which routes to use")
+
+	err = plugin.setWeight(s.ctx, rollout, 10, &GlooEdgeTrafficRouting{
+		PlatformRouteTableSelector: &DumbObjectSelector{Name: "rt1"},
+		Routes:                     []string{"route3"},
+	})
+	assert.EqualError(s.T(), err,
+		"couldn't find stable services in Gloo Platform RouteTables selected with Name: 'rt1', Namespace: '', Labels: map[], with route names in [route3]")
+}
+
+func (s *PlatformRouteTableCanarySuite) Test_verifyWeight_UsingPlatformRouteTables() {
+	plugin, _ := s.newPlugin(
+		newPlatformRouteTable("rt1", nil, newPlatformRoute("route1", newPlatformDestination("stablesvc"))),
+	)
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	pluginConfig := &GlooEdgeTrafficRouting{PlatformRouteTableSelector: &DumbObjectSelector{Name: "rt1"}}
+
+	verified, err := plugin.verifyWeight(s.ctx, rollout, 0, pluginConfig)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), verified, "missing canary destinations match 0 weight")
+
+	verified, err = plugin.verifyWeight(s.ctx, rollout, 20, pluginConfig)
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), verified)
+
+	assert.NoError(s.T(), plugin.setWeight(s.ctx, rollout, 20, pluginConfig))
+
+	verified, err = plugin.verifyWeight(s.ctx, rollout, 20, pluginConfig)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), verified)
+
+	verified, err = plugin.verifyWeight(s.ctx, rollout, 30, pluginConfig)
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), verified)
+}
+
+// failingPatchClient fails patches of the resource with the name unless they're dry runs
+type failingPatchClient struct {
+	client.Client
+	name string
+}
+
+func (c *failingPatchClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	patchOptions := &client.PatchOptions{}
+	patchOptions.ApplyOptions(opts)
+	if obj.GetName() == c.name && len(patchOptions.DryRun) == 0 {
+		return fmt.Errorf("admission webhook denied the request")
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (s *PlatformRouteTableCanarySuite) Test_handleCanary_UsingPlatformRouteTables_RevertsWhenPatchFails() {
+	_, kubeClient := s.newPlugin(
+		newPlatformRouteTable("rt1", map[string]string{"app": "echo"}, newPlatformRoute("route1", newPlatformDestination("stablesvc"))),
+		newPlatformRouteTable("rt2", map[string]string{"app": "echo"}, newPlatformRoute("route1", newPlatformDestination("stablesvc"))),
+	)
+	plugin := &RpcPlugin{
+		Client: gloo.NewGlooV1ClientSetFromClients(s.gwclient, s.glooclient, nil, &failingPatchClient{Client: kubeClient, name: "rt2"}),
+		LogCtx: s.logger.WithContext(s.ctx),
+	}
+
+	err := plugin.setWeight(s.ctx, newTestRollout("stablesvc", "canarysvc"), 10, &GlooEdgeTrafficRouting{
+		PlatformRouteTableSelector: &DumbObjectSelector{Labels: map[string]string{"app": "echo"}},
+	})
+
+	assert.EqualError(s.T(), err,
+		"RouteTable rollout-ns/rt2: admission webhook denied the request (reverted: [RouteTable rollout-ns/rt1])")
+	for _, name := range []string{"rt1", "rt2"} {
+		assert.Len(s.T(), s.getDestinations(kubeClient, name, 0), 1)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
 	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
 	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -20,12 +22,25 @@ const (
 	Type                = "GlooEdgeAPI"
 	GlooEdgeUpdateError = "GlooEdgeUpdateError"
 	PluginName          = "solo-io/glooedge"
+
+	// ManagedByLabel is set on resources created by the plugin
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "argo-rollouts-glooedge-plugin"
+	// RolloutAnnotation holds namespace/name of the Rollout a resource was created for
+	RolloutAnnotation = "glooedge.rollouts.argoproj.io/rollout"
+	// ManagedRoutePrefix is the prefix of names of routes created by the plugin
+	ManagedRoutePrefix = "argo-rollouts-glooedge."
 )
 
 type RpcPlugin struct {
 	IsTest bool
 	LogCtx *logrus.Entry
 	Client gloo.GlooV1ClientSet
+	// When set, VirtualServices and RouteTables are read from a shared informer cache started in InitPlugin
+	UseInformerCache bool
+
+	// locks serialize updates of routing resources shared by Rollouts updated at the same time
+	locks keyedMutex
 }
 
 type GlooEdgeTrafficRouting struct {
@@ -37,6 +52,76 @@ type GlooEdgeTrafficRouting struct {
 	VirtualServiceSelector *DumbObjectSelector `json:"virtualService" protobuf:"bytes,2,name=virtualService"`
 	// The names of routes to use when a destination has more than one. All routes listed here must be present.
 	Routes []string `json:"routes" protobuf:"bytes,3,name=routes"`
+	// The namespace of the canary Upstream. When set, canary destinations are created with and matched by this
+	// namespace, otherwise canary destinations use the namespace of the stable Upstream and are matched by name only.
+	CanaryUpstreamNamespace string `json:"canaryUpstreamNamespace" protobuf:"bytes,4,name=canaryUpstreamNamespace"`
+	// Stable to canary Upstream mappings to use instead of `stableService` and `canaryService` of the canary
+	// strategy. All mappings get the same weights, e.g. when a service is exposed via multiple Upstreams.
+	Upstreams []UpstreamMapping `json:"upstreams" protobuf:"bytes,5,name=upstreams"`
+	// Options to set on canary destinations in addition to the options copied from stable destinations,
+	// e.g. header manipulation that marks requests sent to the canary.
+	CanaryDestinationOptions *DestinationOptions `json:"canaryDestinationOptions" protobuf:"bytes,6,name=canaryDestinationOptions"`
+	// Resilience settings that apply to the canary only while the rollout is in progress. They're removed from
+	// the canary when the rollout is finished.
+	CanaryResilience *CanaryResilience `json:"canaryResilience" protobuf:"bytes,7,name=canaryResilience"`
+	// When set, clients routed to the canary once keep being routed to the canary until the end of the rollout
+	StickySessions *StickySessions `json:"stickySessions" protobuf:"bytes,8,name=stickySessions"`
+	// When set, a preview VirtualService serving this domain is created from the selected VirtualService, with
+	// selected routes sending all traffic to the canary. Requires the VirtualService selector.
+	PreviewDomain string `json:"previewDomain" protobuf:"bytes,9,name=previewDomain"`
+	// The Gateway to use for a canary rollout of TCP services. Weights on selected TCP hosts (see `Routes` field,
+	// which lists TCP host names in this case) of the TCP or hybrid Gateway will be changing during the rollout.
+	// Note that Labels field is not used for selection of Gateway.
+	GatewaySelector *DumbObjectSelector `json:"gateway" protobuf:"bytes,10,name=gateway"`
+	// Gloo Platform RouteTables (networking.gloo.solo.io/v2) to use for a canary rollout. Weights of Service
+	// destinations on selected HTTP routes (see `Routes` field) in these RTs will be changing during the rollout.
+	PlatformRouteTableSelector *DumbObjectSelector `json:"platformRouteTable" protobuf:"bytes,11,name=platformRouteTable"`
+	// Gateway API HTTPRoutes to use for a canary rollout. Weights of backendRefs on selected rules (see `Routes`
+	// field, which lists rule names in this case) in these HTTPRoutes will be changing during the rollout.
+	HTTPRouteSelector *DumbObjectSelector `json:"httpRoute" protobuf:"bytes,12,name=httpRoute"`
+	// The Gloo Fed FederatedVirtualService to use for a canary rollout. Weights on selected routes of its
+	// template will be changing during the rollout. Note that Labels field is not used for selection.
+	FederatedVirtualServiceSelector *DumbObjectSelector `json:"federatedVirtualService" protobuf:"bytes,13,name=federatedVirtualService"`
+	// Gloo Fed FederatedRouteTables to use for a canary rollout. Weights on selected routes of their templates
+	// will be changing during the rollout.
+	FederatedRouteTableSelector *DumbObjectSelector `json:"federatedRouteTable" protobuf:"bytes,14,name=federatedRouteTable"`
+	// When set, only federated resources placed in any of these clusters are used
+	FederationClusters []string `json:"federationClusters" protobuf:"bytes,15,name=federationClusters"`
+	// When set, routing resources are updated with server-side apply using the argo-rollouts-glooedge field
+	// manager instead of JSON patches, so that the fields written by the plugin are visible in managedFields
+	ServerSideApply bool `json:"serverSideApply" protobuf:"varint,16,name=serverSideApply"`
+	// The maximum number of routing resources patched at the same time, defaults to 8
+	PatchConcurrency int32 `json:"patchConcurrency" protobuf:"varint,17,name=patchConcurrency"`
+	// Timeouts of API calls made by the plugin, they override the timeouts set with environment variables
+	APITimeouts *APITimeouts `json:"apiTimeouts" protobuf:"bytes,18,name=apiTimeouts"`
+}
+
+// DestinationOptions are WeightedDestinationOptions (un)marshalled using protobuf JSON mapping
+type DestinationOptions struct {
+	*v1.WeightedDestinationOptions
+}
+
+func (o *DestinationOptions) UnmarshalJSON(b []byte) error {
+	o.WeightedDestinationOptions = &v1.WeightedDestinationOptions{}
+	return protojson.Unmarshal(b, o.WeightedDestinationOptions)
+}
+
+func (o DestinationOptions) MarshalJSON() ([]byte, error) {
+	return protojson.Marshal(o.WeightedDestinationOptions)
+}
+
+type UpstreamMapping struct {
+	// The name of the stable Upstream
+	Stable string `json:"stable" protobuf:"bytes,1,name=stable"`
+	// The name of the canary Upstream
+	Canary string `json:"canary" protobuf:"bytes,2,name=canary"`
+	// The namespace of the canary Upstream, defaults to `canaryUpstreamNamespace`
+	CanaryNamespace string `json:"canaryNamespace" protobuf:"bytes,3,name=canaryNamespace"`
+	// The names of routes that use the stable Upstream, defaults to `routes`
+	Routes []string `json:"routes" protobuf:"bytes,4,name=routes"`
+	// The name of the Service a missing canary Upstream is created for, defaults to `canaryService` of the
+	// canary strategy
+	CanaryService string `json:"canaryService" protobuf:"bytes,5,name=canaryService"`
 }
 
 type DumbObjectSelector struct {
@@ -49,6 +134,7 @@ type destinationPair struct {
 	DestinationsParent *v1.RouteAction
 	Canary             *v1.WeightedDestination
 	Stable             *v1.WeightedDestination
+	Mapping            *UpstreamMapping
 }
 
 type routeTableWithDestinations struct {
@@ -60,7 +146,13 @@ func (r *RpcPlugin) InitPlugin() pluginTypes.RpcError {
 	if r.IsTest {
 		return pluginTypes.RpcError{}
 	}
-	client, err := gloo.NewGlooV1ClientSet()
+	var client gloo.GlooV1ClientSet
+	var err error
+	if r.UseInformerCache {
+		client, err = gloo.NewCachedGlooV1ClientSet(context.Background())
+	} else {
+		client, err = gloo.NewGlooV1ClientSet()
+	}
 	if err != nil {
 		return pluginTypes.RpcError{
 			ErrorString: err.Error(),
@@ -79,7 +171,11 @@ func (r *RpcPlugin) SetWeight(
 	desiredWeight int32,
 	additionalDestinations []v1alpha1.WeightDestination) pluginTypes.RpcError {
 
-	ctx := context.TODO()
+	if err := checkCanaryStrategy(rollout); err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
 	if getStableServiceName(rollout) == "" || getCanaryServiceName(rollout) == "" {
 		return pluginTypes.RpcError{
 			ErrorString: "stableService and/or canaryService fields of canary strategy must be set",
@@ -92,19 +188,10 @@ func (r *RpcPlugin) SetWeight(
 		}
 	}
 
-	if (glooPluginConfig.VirtualServiceSelector == nil && glooPluginConfig.RouteTableSelector == nil) ||
-		(glooPluginConfig.VirtualServiceSelector != nil && glooPluginConfig.RouteTableSelector != nil) {
-		return pluginTypes.RpcError{
-			ErrorString: "one of virtualService or routeTable selectors must be set in solo-io/glooedge plugin configuration",
-		}
-	}
-
-	if glooPluginConfig.VirtualServiceSelector != nil {
-		err = r.handleCanaryUsingVirtualService(ctx, rollout, desiredWeight, glooPluginConfig)
-	} else {
-		err = r.handleCanaryUsingRouteTables(ctx, rollout, desiredWeight, glooPluginConfig)
-	}
-
+	ctx := gloo.ContextWithTimeouts(context.Background(), r.getAPITimeouts(glooPluginConfig))
+	err = r.retryOnConflict(func() error {
+		return r.setWeight(ctx, rollout, desiredWeight, glooPluginConfig)
+	})
 	if err != nil {
 		return pluginTypes.RpcError{
 			ErrorString: fmt.Sprintf("failed canary rollout: %s", err),
@@ -123,11 +210,61 @@ func (r *RpcPlugin) SetMirrorRoute(rollout *v1alpha1.Rollout, setMirrorRoute *v1
 }
 
 func (r *RpcPlugin) VerifyWeight(rollout *v1alpha1.Rollout, desiredWeight int32, additionalDestinations []v1alpha1.WeightDestination) (pluginTypes.RpcVerified, pluginTypes.RpcError) {
-	return pluginTypes.NotImplemented, pluginTypes.RpcError{}
+	if err := checkCanaryStrategy(rollout); err != nil {
+		return pluginTypes.NotVerified, pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+	if getStableServiceName(rollout) == "" || getCanaryServiceName(rollout) == "" {
+		return pluginTypes.NotVerified, pluginTypes.RpcError{
+			ErrorString: "stableService and/or canaryService fields of canary strategy must be set",
+		}
+	}
+	glooPluginConfig, err := getPluginConfig(rollout)
+	if err != nil {
+		return pluginTypes.NotVerified, pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+
+	ctx := gloo.ContextWithTimeouts(context.Background(), r.getAPITimeouts(glooPluginConfig))
+	verified, err := r.verifyWeight(ctx, rollout, desiredWeight, glooPluginConfig)
+	if err != nil {
+		return pluginTypes.NotVerified, pluginTypes.RpcError{
+			ErrorString: fmt.Sprintf("failed to verify weight: %s", err),
+		}
+	}
+	if !verified {
+		return pluginTypes.NotVerified, pluginTypes.RpcError{}
+	}
+
+	return pluginTypes.Verified, pluginTypes.RpcError{}
 }
 
 func (r *RpcPlugin) RemoveManagedRoutes(rollout *v1alpha1.Rollout) pluginTypes.RpcError {
-	// we could remove the canary destination, but not required since it will have 0 weight at the end of rollout
+	// Canary destinations pointing to user-managed upstreams are left in place, they will have 0 weight at
+	// the end of rollout. Canary destinations pointing to upstreams created by the plugin are removed along
+	// with the upstreams.
+	if getStableServiceName(rollout) == "" || getCanaryServiceName(rollout) == "" {
+		return pluginTypes.RpcError{}
+	}
+	glooPluginConfig, err := getPluginConfig(rollout)
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+
+	ctx := gloo.ContextWithTimeouts(context.Background(), r.getAPITimeouts(glooPluginConfig))
+	err = r.retryOnConflict(func() error {
+		return r.removeManagedRoutes(ctx, rollout, glooPluginConfig)
+	})
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: fmt.Sprintf("failed to remove managed routes: %s", err),
+		}
+	}
+
 	return pluginTypes.RpcError{}
 }
 
@@ -138,6 +275,9 @@ func (r *RpcPlugin) Type() string {
 func getPluginConfig(rollout *v1alpha1.Rollout) (*GlooEdgeTrafficRouting, error) {
 	glooplatformConfig := GlooEdgeTrafficRouting{}
 
+	if err := checkCanaryStrategy(rollout); err != nil {
+		return nil, err
+	}
 	if rollout.Spec.Strategy.Canary.TrafficRouting == nil {
 		return nil, fmt.Errorf("trafficRouting configuration is missing in canary strategy")
 	}
@@ -146,17 +286,153 @@ func getPluginConfig(rollout *v1alpha1.Rollout) (*GlooEdgeTrafficRouting, error)
 		return nil, err
 	}
 
+	selectors := 0
+	for _, selector := range []*DumbObjectSelector{
+		glooplatformConfig.VirtualServiceSelector,
+		glooplatformConfig.RouteTableSelector,
+		glooplatformConfig.GatewaySelector,
+		glooplatformConfig.PlatformRouteTableSelector,
+		glooplatformConfig.HTTPRouteSelector,
+		glooplatformConfig.FederatedVirtualServiceSelector,
+		glooplatformConfig.FederatedRouteTableSelector,
+	} {
+		if selector != nil {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return nil, fmt.Errorf("one of virtualService, routeTable, gateway, platformRouteTable, httpRoute, federatedVirtualService or federatedRouteTable selectors must be set in solo-io/glooedge plugin configuration")
+	}
+
+	if glooplatformConfig.PreviewDomain != "" && glooplatformConfig.VirtualServiceSelector == nil {
+		return nil, fmt.Errorf("previewDomain requires virtualService selector in solo-io/glooedge plugin configuration")
+	}
+
+	if glooplatformConfig.FederatedVirtualServiceSelector != nil || glooplatformConfig.FederatedRouteTableSelector != nil {
+		// Upstreams and routes of federated resources live in the placement clusters
+		if glooplatformConfig.CanaryResilience != nil || glooplatformConfig.StickySessions != nil || glooplatformConfig.PreviewDomain != "" {
+			return nil, fmt.Errorf("canaryResilience, stickySessions and previewDomain aren't supported with federatedVirtualService and federatedRouteTable selectors in solo-io/glooedge plugin configuration")
+		}
+	}
+
+	// destinations of Gloo Platform RouteTables and HTTPRoutes reference Services, Gloo Edge destination options and
+	// Upstreams don't apply
+	if glooplatformConfig.PlatformRouteTableSelector != nil || glooplatformConfig.HTTPRouteSelector != nil {
+		if glooplatformConfig.CanaryDestinationOptions != nil || glooplatformConfig.CanaryResilience != nil || glooplatformConfig.StickySessions != nil {
+			return nil, fmt.Errorf("canaryDestinationOptions, canaryResilience and stickySessions aren't supported with platformRouteTable and httpRoute selectors in solo-io/glooedge plugin configuration")
+		}
+	}
+
+	// TCP hosts have no routes to send sticky sessions to the canary or to set retries and timeout on, and header
+	// manipulation of destination options doesn't apply to TCP traffic
+	if glooplatformConfig.GatewaySelector != nil {
+		resilience := glooplatformConfig.CanaryResilience
+		if glooplatformConfig.StickySessions != nil || glooplatformConfig.CanaryDestinationOptions != nil ||
+			(resilience != nil && (resilience.Retries != nil || resilience.Timeout != nil)) {
+			return nil, fmt.Errorf("stickySessions, canaryDestinationOptions and canaryResilience retries and timeout aren't supported with gateway selector in solo-io/glooedge plugin configuration")
+		}
+	}
+
+	// there are no routes sending traffic to the canary only in a weighted split, canary retries and timeout apply
+	// to sticky and preview routes
+	if resilience := glooplatformConfig.CanaryResilience; resilience != nil && (resilience.Retries != nil || resilience.Timeout != nil) &&
+		glooplatformConfig.StickySessions == nil && glooplatformConfig.PreviewDomain == "" {
+		return nil, fmt.Errorf("canaryResilience retries and timeout require stickySessions or previewDomain in solo-io/glooedge plugin configuration")
+	}
+
 	return &glooplatformConfig, nil
 }
 
+// checkCanaryStrategy returns an error for rollouts that don't use the canary strategy. Argo Rollouts supports
+// traffic routing plugins in the canary strategy only, blueGreen rollouts switch traffic by updating selectors
+// of active and preview services.
+func checkCanaryStrategy(rollout *v1alpha1.Rollout) error {
+	if rollout.Spec.Strategy.Canary == nil {
+		return fmt.Errorf("solo-io/glooedge plugin supports canary strategy only")
+	}
+	return nil
+}
+
 func getStableServiceName(rollout *v1alpha1.Rollout) string {
+	if rollout.Spec.Strategy.Canary == nil {
+		return ""
+	}
+	if pingPong := rollout.Spec.Strategy.Canary.PingPong; pingPong != nil {
+		if isStablePing(rollout) {
+			return pingPong.PingService
+		}
+		return pingPong.PongService
+	}
 	return rollout.Spec.Strategy.Canary.StableService
 }
 
 func getCanaryServiceName(rollout *v1alpha1.Rollout) string {
+	if rollout.Spec.Strategy.Canary == nil {
+		return ""
+	}
+	if pingPong := rollout.Spec.Strategy.Canary.PingPong; pingPong != nil {
+		if isStablePing(rollout) {
+			return pingPong.PongService
+		}
+		return pingPong.PingService
+	}
 	return rollout.Spec.Strategy.Canary.CanaryService
 }
 
+// isStablePing returns true when the ping service points to the stable ReplicaSet. Argo Rollouts switches
+// status.canary.stablePingPong at the end of each rollout, the pong service is stable when it's not set.
+func isStablePing(rollout *v1alpha1.Rollout) bool {
+	return rollout.Status.Canary.StablePingPong == v1alpha1.PPPing
+}
+
+// isRolloutFinished returns true when the rollout was fully promoted or aborted. Argo Rollouts calls
+// RemoveManagedRoutes on each reconciliation of a finished rollout, followed by SetWeight with 0 weight.
+func isRolloutFinished(rollout *v1alpha1.Rollout) bool {
+	return rollout.Status.Abort ||
+		(rollout.Status.StableRS != "" && rollout.Status.StableRS == rollout.Status.CurrentPodHash)
+}
+
+func isManagedRoute(route *gwv1.Route) bool {
+	return strings.HasPrefix(route.GetName(), ManagedRoutePrefix)
+}
+
+// unmanagedRoutes returns routes that weren't created by the plugin
+func unmanagedRoutes(routes []*gwv1.Route) []*gwv1.Route {
+	ret := make([]*gwv1.Route, 0, len(routes))
+	for _, route := range routes {
+		if !isManagedRoute(route) {
+			ret = append(ret, route)
+		}
+	}
+	return ret
+}
+
+// getUpstreamMappings returns the stable to canary Upstream mappings from plugin configuration or, when there are
+// none, a mapping between stable and canary services of the rollout
+func getUpstreamMappings(rollout *v1alpha1.Rollout, pluginConfig *GlooEdgeTrafficRouting) []*UpstreamMapping {
+	if len(pluginConfig.Upstreams) == 0 {
+		return []*UpstreamMapping{{
+			Stable:          getStableServiceName(rollout),
+			Canary:          getCanaryServiceName(rollout),
+			CanaryNamespace: pluginConfig.CanaryUpstreamNamespace,
+			Routes:          pluginConfig.Routes,
+		}}
+	}
+
+	ret := make([]*UpstreamMapping, len(pluginConfig.Upstreams))
+	for i := range pluginConfig.Upstreams {
+		mapping := pluginConfig.Upstreams[i]
+		if mapping.CanaryNamespace == "" {
+			mapping.CanaryNamespace = pluginConfig.CanaryUpstreamNamespace
+		}
+		if len(mapping.Routes) == 0 {
+			mapping.Routes = pluginConfig.Routes
+		}
+		ret[i] = &mapping
+	}
+	return ret
+}
+
 func (r *RpcPlugin) maybeConvertSingleToMulti(routeTables []routeTableWithDestinations) {
 	for i := range routeTables {
 		for j := range routeTables[i].Destinations {
@@ -174,8 +450,7 @@ func (r *RpcPlugin) maybeConvertSingleToMulti(routeTables []routeTableWithDestin
 	}
 }
 
-func (r *RpcPlugin) maybeCreateCanaryDestinations(
-	routeTables []routeTableWithDestinations, canaryName string) {
+func (r *RpcPlugin) maybeCreateCanaryDestinations(routeTables []routeTableWithDestinations) {
 
 	for i := range routeTables {
 		for j := range routeTables[i].Destinations {
@@ -184,16 +459,55 @@ func (r *RpcPlugin) maybeCreateCanaryDestinations(
 				continue
 			}
 			routeTables[i].Destinations[j].Canary =
-				r.newCanaryDestination(routeTables[i].Destinations[j].Stable, canaryName)
+				r.newCanaryDestination(routeTables[i].Destinations[j].Stable, routeTables[i].Destinations[j].Mapping)
 			routeTables[i].Destinations[j].DestinationsParent.GetMulti().Destinations =
 				append(routeTables[i].Destinations[j].DestinationsParent.GetMulti().GetDestinations(), routeTables[i].Destinations[j].Canary)
 		}
 	}
 }
 
-func (r *RpcPlugin) newCanaryDestination(stableDst *v1.WeightedDestination, canaryName string) *v1.WeightedDestination {
+// getCanaryDestinationOptions returns canary-specific destination options from plugin configuration,
+// including the options required for sticky sessions
+func getCanaryDestinationOptions(rollout *v1alpha1.Rollout, pluginConfig *GlooEdgeTrafficRouting) *v1.WeightedDestinationOptions {
+	var ret *v1.WeightedDestinationOptions
+	if pluginConfig.CanaryDestinationOptions != nil && pluginConfig.CanaryDestinationOptions.WeightedDestinationOptions != nil {
+		ret = pluginConfig.CanaryDestinationOptions.Clone().(*v1.WeightedDestinationOptions)
+	}
+	if pluginConfig.StickySessions != nil {
+		if ret == nil {
+			ret = &v1.WeightedDestinationOptions{}
+		}
+		proto.Merge(ret, stickyCookieOptions(rollout, pluginConfig.StickySessions))
+	}
+	return ret
+}
+
+// applyCanaryDestinationOptions sets options of canary destinations to options of the corresponding stable
+// destinations merged with canary-specific options. Canary destination options are left untouched when
+// there are no canary-specific options.
+func (r *RpcPlugin) applyCanaryDestinationOptions(routeTables []routeTableWithDestinations, canaryOptions *v1.WeightedDestinationOptions) {
+	if canaryOptions == nil {
+		return
+	}
+
+	for i := range routeTables {
+		for _, dst := range routeTables[i].Destinations {
+			options := &v1.WeightedDestinationOptions{}
+			if dst.Stable.GetOptions() != nil {
+				options = dst.Stable.GetOptions().Clone().(*v1.WeightedDestinationOptions)
+			}
+			proto.Merge(options, canaryOptions)
+			dst.Canary.Options = options
+		}
+	}
+}
+
+func (r *RpcPlugin) newCanaryDestination(stableDst *v1.WeightedDestination, mapping *UpstreamMapping) *v1.WeightedDestination {
 	ret := stableDst.Clone().(*v1.WeightedDestination)
-	ret.GetDestination().GetUpstream().Name = canaryName
+	ret.GetDestination().GetUpstream().Name = mapping.Canary
+	if mapping.CanaryNamespace != "" {
+		ret.GetDestination().GetUpstream().Namespace = mapping.CanaryNamespace
+	}
 	ret.Weight = &wrapperspb.UInt32Value{Value: uint32(0)}
 	return ret
 }
@@ -203,8 +517,20 @@ func (r *RpcPlugin) getDestinationsInRoutes(
 	rollout *v1alpha1.Rollout,
 	pluginConfig *GlooEdgeTrafficRouting) (ret []destinationPair) {
 
+	for _, mapping := range getUpstreamMappings(rollout, pluginConfig) {
+		ret = append(ret, r.getMappedDestinationsInRoutes(routes, mapping)...)
+	}
+
+	return ret
+}
+
+func (r *RpcPlugin) getMappedDestinationsInRoutes(routes []*gwv1.Route, mapping *UpstreamMapping) (ret []destinationPair) {
 	for _, route := range routes {
-		if len(pluginConfig.Routes) > 0 && !slices.Contains(pluginConfig.Routes, route.GetName()) {
+		if isManagedRoute(route) {
+			continue
+		}
+
+		if len(mapping.Routes) > 0 && !slices.Contains(mapping.Routes, route.GetName()) {
 			continue
 		}
 
@@ -214,19 +540,19 @@ func (r *RpcPlugin) getDestinationsInRoutes(
 		}
 
 		if route.GetRouteAction().GetSingle() != nil {
-			ret = append(ret, r.getDestinationInSingle(route, rollout)...)
+			ret = append(ret, r.getDestinationInSingle(route, mapping)...)
 			continue
 		}
 
 		if route.GetRouteAction().GetMulti().GetDestinations() != nil {
-			ret = append(ret, r.getDestinationsInMulti(route, rollout)...)
+			ret = append(ret, r.getDestinationsInMulti(route, mapping)...)
 		}
 	}
 
 	return ret
 }
 
-func (r *RpcPlugin) getDestinationsInMulti(route *gwv1.Route, rollout *v1alpha1.Rollout) (ret []destinationPair) {
+func (r *RpcPlugin) getDestinationsInMulti(route *gwv1.Route, mapping *UpstreamMapping) (ret []destinationPair) {
 	var stable, canary *v1.WeightedDestination
 	for _, dst := range route.GetRouteAction().GetMulti().GetDestinations() {
 		if dst.GetDestination().GetUpstream() == nil ||
@@ -234,21 +560,22 @@ func (r *RpcPlugin) getDestinationsInMulti(route *gwv1.Route, rollout *v1alpha1.
 			continue
 		}
 		name := dst.GetDestination().GetUpstream().GetName()
-		if strings.EqualFold(getCanaryServiceName(rollout), name) {
+		if strings.EqualFold(mapping.Canary, name) &&
+			(mapping.CanaryNamespace == "" || mapping.CanaryNamespace == dst.GetDestination().GetUpstream().GetNamespace()) {
 			canary = dst
-		} else if strings.EqualFold(getStableServiceName(rollout), name) {
+		} else if strings.EqualFold(mapping.Stable, name) {
 			stable = dst
 		}
 	}
 	if stable != nil {
-		ret = append(ret, destinationPair{DestinationsParent: route.GetRouteAction(), Stable: stable, Canary: canary})
+		ret = append(ret, destinationPair{DestinationsParent: route.GetRouteAction(), Stable: stable, Canary: canary, Mapping: mapping})
 	}
 
 	return ret
 }
 
 // We will be converting `single` RouteAction to a `multi` one that will use WeightedDestinations created here
-func (r *RpcPlugin) getDestinationInSingle(route *gwv1.Route, rollout *v1alpha1.Rollout) (ret []destinationPair) {
+func (r *RpcPlugin) getDestinationInSingle(route *gwv1.Route, mapping *UpstreamMapping) (ret []destinationPair) {
 	var stable *v1.WeightedDestination
 
 	dst := route.GetRouteAction().GetSingle()
@@ -256,11 +583,11 @@ func (r *RpcPlugin) getDestinationInSingle(route *gwv1.Route, rollout *v1alpha1.
 		return ret
 	}
 
-	if strings.EqualFold(getStableServiceName(rollout), dst.GetUpstream().GetName()) {
+	if strings.EqualFold(mapping.Stable, dst.GetUpstream().GetName()) {
 		stable = &v1.WeightedDestination{
 			Destination: dst,
 		}
-		ret = append(ret, destinationPair{DestinationsParent: route.GetRouteAction(), Stable: stable})
+		ret = append(ret, destinationPair{DestinationsParent: route.GetRouteAction(), Stable: stable, Mapping: mapping})
 	}
 
 	return ret
@@ -2,15 +2,20 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
 	"github.com/golang/mock/gomock"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
 	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
 	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/options/headers"
 	"github.com/solo-io/solo-kit/pkg/api/v1/resources/core"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -23,6 +28,8 @@ type PluginSuite struct {
 	ctrl       *gomock.Controller
 	ctx        context.Context
 	gwclient   *gloov1.MockClientset
+	glooclient *gloomocks.MockClientset
+	usclient   *gloomocks.MockUpstreamClient
 	loggerHook *test.Hook
 }
 
@@ -30,11 +37,13 @@ func (s *PluginSuite) SetupTest() {
 	s.ctx = context.TODO()
 	s.ctrl = gomock.NewController(s.T())
 	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	s.usclient = gloomocks.NewMockUpstreamClient(s.ctrl)
 	var testLogger *logrus.Logger
 	// see https://github.com/mpchadwick/dbanon/blob/v0.6.0/src/provider_test.go#L39-L42
 	// for example of how to use the hook in tests
 	testLogger, s.loggerHook = test.NewNullLogger()
-	s.plugin = &RpcPlugin{Client: s.gwclient, LogCtx: testLogger.WithContext(s.ctx)}
+	s.plugin = &RpcPlugin{Client: gloo.NewGlooV1ClientSetFromClientsets(s.gwclient, s.glooclient), LogCtx: testLogger.WithContext(s.ctx)}
 }
 
 func TestPluginSuite(t *testing.T) {
@@ -381,7 +390,8 @@ func (s *PluginSuite) Test_maybeCreateCanaryDestinations() {
 						},
 						Weight: wrapperspb.UInt32(uint32(90)),
 					},
-					Canary: nil,
+					Canary:  nil,
+					Mapping: &UpstreamMapping{Canary: canarysvc},
 				},
 				{
 					DestinationsParent: &v1.RouteAction{
@@ -400,7 +410,8 @@ func (s *PluginSuite) Test_maybeCreateCanaryDestinations() {
 							},
 						},
 					},
-					Canary: nil,
+					Canary:  nil,
+					Mapping: &UpstreamMapping{Canary: canarysvc},
 				},
 			},
 		},
@@ -424,7 +435,8 @@ func (s *PluginSuite) Test_maybeCreateCanaryDestinations() {
 						},
 						Weight: wrapperspb.UInt32(uint32(90)),
 					},
-					Canary: nil,
+					Canary:  nil,
+					Mapping: &UpstreamMapping{Canary: canarysvc},
 				},
 				{
 					DestinationsParent: &v1.RouteAction{
@@ -443,13 +455,14 @@ func (s *PluginSuite) Test_maybeCreateCanaryDestinations() {
 							},
 						},
 					},
-					Canary: nil,
+					Canary:  nil,
+					Mapping: &UpstreamMapping{Canary: canarysvc},
 				},
 			},
 		},
 	}
 
-	s.plugin.maybeCreateCanaryDestinations(rts, canarysvc)
+	s.plugin.maybeCreateCanaryDestinations(rts)
 
 	for i := 0; i < 2; i++ {
 		for j := 0; j < 2; j++ {
@@ -462,6 +475,300 @@ func (s *PluginSuite) Test_maybeCreateCanaryDestinations() {
 	}
 }
 
+func (s *PluginSuite) Test_getDestinationsInRoutes_MatchesCanaryUpstreamNamespace() {
+	stableUpstreamName := "stable-upstream"
+	canaryUpstreamName := "canary-upstream"
+	canaryNs := "canary-ns"
+
+	routes := []*gwv1.Route{
+		{
+			Name: "route-1",
+			Action: &gwv1.Route_RouteAction{
+				RouteAction: &v1.RouteAction{
+					Destination: &v1.RouteAction_Multi{
+						Multi: &v1.MultiDestination{
+							Destinations: []*v1.WeightedDestination{
+								newUpstreamDestination(stableUpstreamName, "stable-ns", 100),
+								// same name, but in the namespace of stable upstream
+								newUpstreamDestination(canaryUpstreamName, "stable-ns", 0),
+								newUpstreamDestination(canaryUpstreamName, canaryNs, 0),
+							},
+						},
+					},
+				}},
+		},
+		{
+			Name: "route-2",
+			Action: &gwv1.Route_RouteAction{
+				RouteAction: &v1.RouteAction{
+					Destination: &v1.RouteAction_Multi{
+						Multi: &v1.MultiDestination{
+							Destinations: []*v1.WeightedDestination{
+								newUpstreamDestination(stableUpstreamName, "stable-ns", 100),
+								newUpstreamDestination(canaryUpstreamName, "stable-ns", 0),
+							},
+						},
+					},
+				}},
+		},
+	}
+
+	dsts := s.plugin.getDestinationsInRoutes(
+		routes,
+		newTestRollout(stableUpstreamName, canaryUpstreamName),
+		&GlooEdgeTrafficRouting{
+			CanaryUpstreamNamespace: canaryNs,
+		})
+
+	assert.Len(s.T(), dsts, 2)
+	assert.Equal(s.T(), routes[0].GetRouteAction().GetMulti().GetDestinations()[2], dsts[0].Canary)
+	assert.Nil(s.T(), dsts[1].Canary)
+
+	s.plugin.maybeCreateCanaryDestinations([]routeTableWithDestinations{{Destinations: dsts}})
+
+	assert.Len(s.T(), routes[1].GetRouteAction().GetMulti().GetDestinations(), 3)
+	assert.Equal(s.T(), canaryNs, dsts[1].Canary.GetDestination().GetUpstream().GetNamespace())
+	assert.Equal(s.T(), canaryUpstreamName, dsts[1].Canary.GetDestination().GetUpstream().GetName())
+}
+
+func (s *PluginSuite) Test_getUpstreamMappings() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+
+	assert.Equal(s.T(),
+		[]*UpstreamMapping{{Stable: "stablesvc", Canary: "canarysvc", CanaryNamespace: "canary-ns", Routes: []string{"route-1"}}},
+		getUpstreamMappings(rollout, &GlooEdgeTrafficRouting{
+			Routes:                  []string{"route-1"},
+			CanaryUpstreamNamespace: "canary-ns",
+		}))
+
+	assert.Equal(s.T(),
+		[]*UpstreamMapping{
+			{Stable: "http-stable", Canary: "http-canary", CanaryNamespace: "canary-ns", Routes: []string{"http"}},
+			{Stable: "grpc-stable", Canary: "grpc-canary", CanaryNamespace: "grpc-ns", Routes: []string{"route-1"}},
+		},
+		getUpstreamMappings(rollout, &GlooEdgeTrafficRouting{
+			Routes:                  []string{"route-1"},
+			CanaryUpstreamNamespace: "canary-ns",
+			Upstreams: []UpstreamMapping{
+				{Stable: "http-stable", Canary: "http-canary", Routes: []string{"http"}},
+				{Stable: "grpc-stable", Canary: "grpc-canary", CanaryNamespace: "grpc-ns"},
+			},
+		}))
+}
+
+func (s *PluginSuite) Test_applyCanaryDestinationOptions() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	rollout.Spec.Strategy.Canary.TrafficRouting = &v1alpha1.RolloutTrafficRouting{
+		Plugins: map[string]json.RawMessage{
+			PluginName: []byte(`{
+				"virtualService": {"name": "vs"},
+				"canaryDestinationOptions": {
+					"headerManipulation": {
+						"requestHeadersToAdd": [{"header": {"key": "x-canary", "value": "true"}}],
+						"responseHeadersToAdd": [{"header": {"key": "x-canary", "value": "true"}}]
+					}
+				}
+			}`),
+		},
+	}
+	pluginConfig, err := getPluginConfig(rollout)
+	assert.NoError(s.T(), err)
+
+	stable := newUpstreamDestination("stablesvc", "", 100)
+	stable.Options = &v1.WeightedDestinationOptions{
+		HeaderManipulation: &headers.HeaderManipulation{RequestHeadersToRemove: []string{"x-debug"}},
+	}
+	canary := newUpstreamDestination("canarysvc", "", 0)
+	rts := []routeTableWithDestinations{{Destinations: []destinationPair{{Stable: stable, Canary: canary}}}}
+
+	// applying options repeatedly doesn't accumulate them
+	s.plugin.applyCanaryDestinationOptions(rts, pluginConfig.CanaryDestinationOptions.WeightedDestinationOptions)
+	s.plugin.applyCanaryDestinationOptions(rts, pluginConfig.CanaryDestinationOptions.WeightedDestinationOptions)
+
+	headerManipulation := canary.GetOptions().GetHeaderManipulation()
+	assert.Equal(s.T(), []string{"x-debug"}, headerManipulation.GetRequestHeadersToRemove())
+	assert.Len(s.T(), headerManipulation.GetRequestHeadersToAdd(), 1)
+	assert.Equal(s.T(), "x-canary", headerManipulation.GetRequestHeadersToAdd()[0].GetHeader().GetKey())
+	assert.Len(s.T(), headerManipulation.GetResponseHeadersToAdd(), 1)
+	assert.Equal(s.T(), "true", headerManipulation.GetResponseHeadersToAdd()[0].GetHeader().GetValue())
+	// stable destination isn't affected
+	assert.Empty(s.T(), stable.GetOptions().GetHeaderManipulation().GetRequestHeadersToAdd())
+}
+
+func (s *PluginSuite) Test_getServiceNames_PingPong() {
+	rollout := newTestRollout("", "")
+	rollout.Spec.Strategy.Canary.PingPong = &v1alpha1.PingPongSpec{PingService: "ping", PongService: "pong"}
+
+	assert.Equal(s.T(), "pong", getStableServiceName(rollout))
+	assert.Equal(s.T(), "ping", getCanaryServiceName(rollout))
+
+	rollout.Status.Canary.StablePingPong = v1alpha1.PPPing
+
+	assert.Equal(s.T(), "ping", getStableServiceName(rollout))
+	assert.Equal(s.T(), "pong", getCanaryServiceName(rollout))
+}
+
+func (s *PluginSuite) Test_getPluginConfig_ReturnsErrorForBlueGreen() {
+	_, err := getPluginConfig(&v1alpha1.Rollout{
+		Spec: v1alpha1.RolloutSpec{
+			Strategy: v1alpha1.RolloutStrategy{
+				BlueGreen: &v1alpha1.BlueGreenStrategy{
+					ActiveService:  "active",
+					PreviewService: "preview",
+				},
+			},
+		},
+	})
+
+	assert.EqualError(s.T(), err, "solo-io/glooedge plugin supports canary strategy only")
+}
+
+func newRolloutWithPluginConfig(pluginConfig string) *v1alpha1.Rollout {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	rollout.Spec.Strategy.Canary.TrafficRouting = &v1alpha1.RolloutTrafficRouting{
+		Plugins: map[string]json.RawMessage{PluginName: []byte(pluginConfig)},
+	}
+	return rollout
+}
+
+func (s *PluginSuite) Test_getPluginConfig_ValidatesCanaryResilience() {
+	type testCase struct {
+		config        string
+		expectedError string
+	}
+	for name, tc := range map[string]testCase{
+		"retries without canary routes": {
+			config:        `{"virtualService": {"name": "vs"}, "canaryResilience": {"retries": {"numRetries": 3}}}`,
+			expectedError: "canaryResilience retries and timeout require stickySessions or previewDomain in solo-io/glooedge plugin configuration",
+		},
+		"timeout without canary routes": {
+			config:        `{"routeTable": {"name": "rt"}, "canaryResilience": {"timeout": "5s"}}`,
+			expectedError: "canaryResilience retries and timeout require stickySessions or previewDomain in solo-io/glooedge plugin configuration",
+		},
+		"timeout with sticky sessions": {
+			config: `{"routeTable": {"name": "rt"}, "canaryResilience": {"timeout": "5s"}, "stickySessions": {"cookieName": "canary"}}`,
+		},
+		"retries with preview domain": {
+			config: `{"virtualService": {"name": "vs"}, "canaryResilience": {"retries": {"numRetries": 3}}, "previewDomain": "preview.example.com"}`,
+		},
+		"outlier detection without canary routes": {
+			config: `{"virtualService": {"name": "vs"}, "canaryResilience": {"outlierDetection": {"consecutive5xx": 3}}}`,
+		},
+	} {
+		s.Run(name, func() {
+			_, err := getPluginConfig(newRolloutWithPluginConfig(tc.config))
+
+			if tc.expectedError == "" {
+				assert.NoError(s.T(), err)
+			} else {
+				assert.EqualError(s.T(), err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func (s *PluginSuite) Test_SetWeight_ReturnsErrorForBlueGreen() {
+	err := s.plugin.SetWeight(&v1alpha1.Rollout{
+		Spec: v1alpha1.RolloutSpec{
+			Strategy: v1alpha1.RolloutStrategy{
+				BlueGreen: &v1alpha1.BlueGreenStrategy{
+					ActiveService:  "active",
+					PreviewService: "preview",
+				},
+			}}},
+		0, []v1alpha1.WeightDestination{})
+
+	assert.Equal(s.T(), "solo-io/glooedge plugin supports canary strategy only", err.ErrorString)
+}
+
+func (s *PluginSuite) Test_VerifyWeight_ReturnsErrorForBlueGreen() {
+	verified, err := s.plugin.VerifyWeight(&v1alpha1.Rollout{
+		Spec: v1alpha1.RolloutSpec{
+			Strategy: v1alpha1.RolloutStrategy{
+				BlueGreen: &v1alpha1.BlueGreenStrategy{
+					ActiveService:  "active",
+					PreviewService: "preview",
+				},
+			}}},
+		0, []v1alpha1.WeightDestination{})
+
+	assert.Equal(s.T(), pluginTypes.NotVerified, verified)
+	assert.Equal(s.T(), "solo-io/glooedge plugin supports canary strategy only", err.ErrorString)
+}
+
+func (s *PluginSuite) Test_getPluginConfig_RejectsUnsupportedFederatedSettings() {
+	for name, config := range map[string]string{
+		"canary resilience": `{"federatedVirtualService": {"name": "fvs"}, "canaryResilience": {"outlierDetection": {"consecutive5xx": 3}}}`,
+		"sticky sessions":   `{"federatedRouteTable": {"name": "frt"}, "stickySessions": {}}`,
+	} {
+		s.Run(name, func() {
+			_, err := getPluginConfig(newRolloutWithPluginConfig(config))
+
+			assert.EqualError(s.T(), err, "canaryResilience, stickySessions and previewDomain aren't supported with federatedVirtualService "+
+				"and federatedRouteTable selectors in solo-io/glooedge plugin configuration")
+		})
+	}
+}
+
+func (s *PluginSuite) Test_getPluginConfig_RejectsUnsupportedGatewaySettings() {
+	for name, tc := range map[string]struct {
+		config        string
+		expectedError string
+	}{
+		"sticky sessions": {
+			config: `{"gateway": {"name": "gw"}, "stickySessions": {}}`,
+			expectedError: "stickySessions, canaryDestinationOptions and canaryResilience retries and timeout aren't supported " +
+				"with gateway selector in solo-io/glooedge plugin configuration",
+		},
+		"canary destination options": {
+			config: `{"gateway": {"name": "gw"}, "canaryDestinationOptions": {"headerManipulation": {"requestHeadersToRemove": ["x-canary"]}}}`,
+			expectedError: "stickySessions, canaryDestinationOptions and canaryResilience retries and timeout aren't supported " +
+				"with gateway selector in solo-io/glooedge plugin configuration",
+		},
+		"canary retries": {
+			config: `{"gateway": {"name": "gw"}, "canaryResilience": {"retries": {"numRetries": 3}}}`,
+			expectedError: "stickySessions, canaryDestinationOptions and canaryResilience retries and timeout aren't supported " +
+				"with gateway selector in solo-io/glooedge plugin configuration",
+		},
+		"canary timeout": {
+			config: `{"gateway": {"name": "gw"}, "canaryResilience": {"timeout": "5s"}}`,
+			expectedError: "stickySessions, canaryDestinationOptions and canaryResilience retries and timeout aren't supported " +
+				"with gateway selector in solo-io/glooedge plugin configuration",
+		},
+		"canary Upstream resilience": {
+			config: `{"gateway": {"name": "gw"}, "canaryResilience": {"outlierDetection": {"consecutive5xx": 3}}}`,
+		},
+	} {
+		s.Run(name, func() {
+			_, err := getPluginConfig(newRolloutWithPluginConfig(tc.config))
+
+			if tc.expectedError == "" {
+				assert.NoError(s.T(), err)
+			} else {
+				assert.EqualError(s.T(), err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func (s *PluginSuite) Test_getPluginConfig_RejectsUnsupportedPlatformRouteTableAndHTTPRouteSettings() {
+	for name, config := range map[string]string{
+		"platform RouteTable canary destination options": `{"platformRouteTable": {"name": "rt"}, "canaryDestinationOptions": {"headerManipulation": {"requestHeadersToRemove": ["x-canary"]}}}`,
+		"platform RouteTable canary resilience":          `{"platformRouteTable": {"name": "rt"}, "canaryResilience": {"outlierDetection": {"consecutive5xx": 3}}}`,
+		"platform RouteTable sticky sessions":            `{"platformRouteTable": {"name": "rt"}, "stickySessions": {}}`,
+		"HTTPRoute canary destination options":           `{"httpRoute": {"name": "echo"}, "canaryDestinationOptions": {"headerManipulation": {"requestHeadersToRemove": ["x-canary"]}}}`,
+		"HTTPRoute canary resilience":                    `{"httpRoute": {"name": "echo"}, "canaryResilience": {"outlierDetection": {"consecutive5xx": 3}}}`,
+		"HTTPRoute sticky sessions":                      `{"httpRoute": {"name": "echo"}, "stickySessions": {"cookieName": "canary"}}`,
+	} {
+		s.Run(name, func() {
+			_, err := getPluginConfig(newRolloutWithPluginConfig(config))
+
+			assert.EqualError(s.T(), err, "canaryDestinationOptions, canaryResilience and stickySessions aren't supported "+
+				"with platformRouteTable and httpRoute selectors in solo-io/glooedge plugin configuration")
+		})
+	}
+}
+
 // check that we bail if stableService and/or canaryService aren't set
 func (s *PluginSuite) Test_SetWeight_ReturnsErrorWhenServiceNamesAreEmpty() {
 	err := s.plugin.SetWeight(&v1alpha1.Rollout{
@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// previewVirtualServiceKey returns the key of the preview VirtualService of a rollout. It lives in the namespace of
+// the selected VirtualService, so that it's picked up by the same gateways.
+func previewVirtualServiceKey(rollout *v1alpha1.Rollout, vs *gwv1.VirtualService) client.ObjectKey {
+	return client.ObjectKey{
+		Namespace: vs.GetNamespace(),
+		Name:      fmt.Sprintf("%s-%s-preview", rollout.Namespace, rollout.Name),
+	}
+}
+
+// syncPreviewVirtualService creates or updates the preview VirtualService of a rollout, if a preview domain is set.
+// The preview VirtualService is deleted instead when the rollout is finished or there are no canary destinations,
+// e.g. when the weight is set to 0 after RemoveManagedRoutes removed them.
+func (r *RpcPlugin) syncPreviewVirtualService(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	vs *gwv1.VirtualService,
+	destinations []destinationPair,
+	pluginConfig *GlooEdgeTrafficRouting) error {
+
+	if pluginConfig.PreviewDomain == "" {
+		return nil
+	}
+	if isRolloutFinished(rollout) || len(destinations) == 0 {
+		return r.deletePreviewVirtualService(ctx, rollout, vs, pluginConfig)
+	}
+
+	desired := newPreviewVirtualService(rollout, vs, destinations, pluginConfig)
+	key := client.ObjectKeyFromObject(desired)
+
+	existing, err := r.Client.VirtualServices().GetVirtualService(ctx, key)
+	if k8serrors.IsNotFound(err) {
+		r.LogCtx.Debugf("creating preview VirtualService %s for rollout %s/%s", key, rollout.Namespace, rollout.Name)
+		return r.Client.VirtualServices().CreateVirtualService(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+	if !isManagedByRollout(existing, rollout) {
+		return fmt.Errorf("preview VirtualService %s already exists and isn't managed by rollout %s", key, rolloutKey(rollout))
+	}
+	if existing.Spec.Equal(&desired.Spec) {
+		return nil
+	}
+
+	original := &gwv1.VirtualService{}
+	existing.DeepCopyInto(original)
+	desired.Spec.DeepCopyInto(&existing.Spec)
+	return r.Client.VirtualServices().PatchVirtualService(ctx, existing, mergeFromWithOptimisticLock(original))
+}
+
+// deletePreviewVirtualService deletes the preview VirtualService of a rollout, if a preview domain is set and the
+// VirtualService was created by the plugin
+func (r *RpcPlugin) deletePreviewVirtualService(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	vs *gwv1.VirtualService,
+	pluginConfig *GlooEdgeTrafficRouting) error {
+
+	if pluginConfig.PreviewDomain == "" {
+		return nil
+	}
+
+	key := previewVirtualServiceKey(rollout, vs)
+	existing, err := r.Client.VirtualServices().GetVirtualService(ctx, key)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !isManagedByRollout(existing, rollout) {
+		return nil
+	}
+
+	r.LogCtx.Debugf("deleting preview VirtualService %s for rollout %s/%s", key, rollout.Namespace, rollout.Name)
+	return client.IgnoreNotFound(r.Client.VirtualServices().DeleteVirtualService(ctx, key))
+}
+
+// newPreviewVirtualService returns a copy of the VirtualService serving the preview domain, with selected routes
+// sending all traffic to the canary and other routes left out
+func newPreviewVirtualService(
+	rollout *v1alpha1.Rollout,
+	vs *gwv1.VirtualService,
+	destinations []destinationPair,
+	pluginConfig *GlooEdgeTrafficRouting) *gwv1.VirtualService {
+
+	key := previewVirtualServiceKey(rollout, vs)
+	ret := &gwv1.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   key.Namespace,
+			Name:        key.Name,
+			Labels:      map[string]string{ManagedByLabel: ManagedByValue},
+			Annotations: map[string]string{RolloutAnnotation: rolloutKey(rollout)},
+		},
+	}
+	vs.Spec.DeepCopyInto(&ret.Spec)
+	ret.Spec.VirtualHost.Domains = []string{pluginConfig.PreviewDomain}
+	if len(ret.Spec.GetSslConfig().GetSniDomains()) > 0 {
+		ret.Spec.SslConfig.SniDomains = []string{pluginConfig.PreviewDomain}
+	}
+
+	var routes []*gwv1.Route
+	for _, route := range unmanagedRoutes(vs.Spec.GetVirtualHost().GetRoutes()) {
+		var canaries []*v1.WeightedDestination
+		for _, dst := range destinations {
+			if dst.DestinationsParent == route.GetRouteAction() {
+				canaries = append(canaries, dst.Canary)
+			}
+		}
+		if len(canaries) == 0 {
+			continue
+		}
+
+		previewRoute := route.Clone().(*gwv1.Route)
+		previewRoute.Action = &gwv1.Route_RouteAction{RouteAction: canaryOnlyRouteAction(route.GetRouteAction(), canaries)}
+		previewRoute.Options = canaryRouteOptions(route.GetOptions(), pluginConfig.CanaryResilience)
+		routes = append(routes, previewRoute)
+	}
+	ret.Spec.VirtualHost.Routes = routes
+
+	return ret
+}
+
+// canaryOnlyRouteAction returns a copy of the route action that sends all traffic to the canary destinations
+func canaryOnlyRouteAction(routeAction *v1.RouteAction, canaries []*v1.WeightedDestination) *v1.RouteAction {
+	ret := routeAction.Clone().(*v1.RouteAction)
+	if len(canaries) == 1 {
+		ret.Destination = &v1.RouteAction_Single{Single: canaries[0].GetDestination().Clone().(*v1.Destination)}
+		return ret
+	}
+
+	multi := &v1.MultiDestination{}
+	for _, canary := range canaries {
+		dst := canary.Clone().(*v1.WeightedDestination)
+		dst.Weight = wrapperspb.UInt32(1)
+		multi.Destinations = append(multi.Destinations, dst)
+	}
+	ret.Destination = &v1.RouteAction_Multi{Multi: multi}
+	return ret
+}
@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/ssl"
+)
+
+type PreviewSuite struct {
+	suite.Suite
+	plugin     *RpcPlugin
+	ctrl       *gomock.Controller
+	ctx        context.Context
+	gwclient   *gloov1.MockClientset
+	vsclient   *gloov1.MockVirtualServiceClient
+	glooclient *gloomocks.MockClientset
+	loggerHook *test.Hook
+}
+
+func (s *PreviewSuite) SetupTest() {
+	s.ctx = context.TODO()
+	s.ctrl = gomock.NewController(s.T())
+	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.vsclient = gloov1.NewMockVirtualServiceClient(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	var testLogger *logrus.Logger
+	testLogger, s.loggerHook = test.NewNullLogger()
+	s.plugin = &RpcPlugin{Client: gloo.NewGlooV1ClientSetFromClientsets(s.gwclient, s.glooclient), LogCtx: testLogger.WithContext(s.ctx)}
+}
+
+func TestPreviewSuite(t *testing.T) {
+	suite.Run(t, new(PreviewSuite))
+}
+
+var previewKey = client.ObjectKey{Namespace: "testns", Name: "rollout-ns-rollout-preview"}
+
+func newPreviewTestVirtualService() (*gwv1.VirtualService, []destinationPair) {
+	routeAction := &v1.RouteAction{
+		Destination: &v1.RouteAction_Multi{
+			Multi: &v1.MultiDestination{
+				Destinations: []*v1.WeightedDestination{
+					newUpstreamDestination("stablesvc", "", 90),
+					newUpstreamDestination("canarysvc", "", 10),
+				},
+			},
+		},
+	}
+	vs := &gwv1.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "testns", Name: "testvs"},
+		Spec: gwv1.VirtualServiceSpec{
+			SslConfig: &ssl.SslConfig{SniDomains: []string{"api.example.com"}},
+			VirtualHost: &gwv1.VirtualHost{
+				Domains: []string{"api.example.com"},
+				Routes: []*gwv1.Route{
+					{Name: "route1", Action: &gwv1.Route_RouteAction{RouteAction: routeAction}},
+					{
+						Name: "route2",
+						Action: &gwv1.Route_DirectResponseAction{
+							DirectResponseAction: &v1.DirectResponseAction{Status: 404},
+						},
+					},
+				},
+			},
+		},
+	}
+	dsts := []destinationPair{
+		{
+			DestinationsParent: routeAction,
+			Stable:             routeAction.GetMulti().GetDestinations()[0],
+			Canary:             routeAction.GetMulti().GetDestinations()[1],
+		},
+	}
+	return vs, dsts
+}
+
+func (s *PreviewSuite) Test_newPreviewVirtualService() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	vs, dsts := newPreviewTestVirtualService()
+
+	preview := newPreviewVirtualService(rollout, vs, dsts, &GlooEdgeTrafficRouting{PreviewDomain: "canary.api.example.com"})
+
+	assert.Equal(s.T(), previewKey, client.ObjectKeyFromObject(preview))
+	assert.True(s.T(), isManagedByRollout(preview, rollout))
+	assert.Equal(s.T(), []string{"canary.api.example.com"}, preview.Spec.GetVirtualHost().GetDomains())
+	assert.Equal(s.T(), []string{"canary.api.example.com"}, preview.Spec.GetSslConfig().GetSniDomains())
+	assert.Len(s.T(), preview.Spec.GetVirtualHost().GetRoutes(), 1)
+	assert.Equal(s.T(), "route1", preview.Spec.GetVirtualHost().GetRoutes()[0].GetName())
+	assert.Equal(s.T(), "canarysvc",
+		preview.Spec.GetVirtualHost().GetRoutes()[0].GetRouteAction().GetSingle().GetUpstream().GetName())
+	// the selected VirtualService isn't modified
+	assert.Equal(s.T(), []string{"api.example.com"}, vs.Spec.GetVirtualHost().GetDomains())
+	assert.Len(s.T(), vs.Spec.GetVirtualHost().GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations(), 2)
+}
+
+func (s *PreviewSuite) Test_syncPreviewVirtualService_Creates() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	vs, dsts := newPreviewTestVirtualService()
+
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(), gomock.Eq(previewKey)).Times(1).
+		Return(nil, k8serrors.NewNotFound(schema.GroupResource{}, previewKey.Name))
+	s.vsclient.EXPECT().CreateVirtualService(gomock.Any(), gomock.Any()).Times(1).
+		Do(func(_ context.Context, obj *gwv1.VirtualService, _ ...client.CreateOption) {
+			assert.Equal(s.T(), previewKey, client.ObjectKeyFromObject(obj))
+		})
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(2)
+
+	err := s.plugin.syncPreviewVirtualService(s.ctx, rollout, vs, dsts, &GlooEdgeTrafficRouting{PreviewDomain: "canary.api.example.com"})
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *PreviewSuite) Test_syncPreviewVirtualService_FailsWhenNotManaged() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	vs, dsts := newPreviewTestVirtualService()
+
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(), gomock.Eq(previewKey)).Times(1).
+		Return(&gwv1.VirtualService{ObjectMeta: metav1.ObjectMeta{Namespace: previewKey.Namespace, Name: previewKey.Name}}, nil)
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(1)
+
+	err := s.plugin.syncPreviewVirtualService(s.ctx, rollout, vs, dsts, &GlooEdgeTrafficRouting{PreviewDomain: "canary.api.example.com"})
+
+	assert.EqualError(s.T(), err,
+		"preview VirtualService testns/rollout-ns-rollout-preview already exists and isn't managed by rollout rollout-ns/rollout")
+}
+
+func (s *PreviewSuite) Test_deletePreviewVirtualService() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	vs, dsts := newPreviewTestVirtualService()
+	pluginConfig := &GlooEdgeTrafficRouting{PreviewDomain: "canary.api.example.com"}
+
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(), gomock.Eq(previewKey)).Times(1).
+		Return(newPreviewVirtualService(rollout, vs, dsts, pluginConfig), nil)
+	s.vsclient.EXPECT().DeleteVirtualService(gomock.Any(), gomock.Eq(previewKey)).Times(1)
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(2)
+
+	err := s.plugin.deletePreviewVirtualService(s.ctx, rollout, vs, pluginConfig)
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *PreviewSuite) Test_syncPreviewVirtualService_DeletesPreviewOfFinishedRollouts() {
+	pluginConfig := &GlooEdgeTrafficRouting{PreviewDomain: "canary.api.example.com"}
+	aborted := newTestRollout("stablesvc", "canarysvc")
+	aborted.Status.Abort = true
+	promoted := newTestRollout("stablesvc", "canarysvc")
+	promoted.Status.StableRS = "abc123"
+	promoted.Status.CurrentPodHash = "abc123"
+
+	for name, tc := range map[string]struct {
+		rollout    *v1alpha1.Rollout
+		withCanary bool
+	}{
+		"aborted rollout":        {rollout: aborted, withCanary: true},
+		"fully promoted rollout": {rollout: promoted, withCanary: true},
+		"no canary destinations": {rollout: newTestRollout("stablesvc", "canarysvc")},
+	} {
+		s.Run(name, func() {
+			vs, dsts := newPreviewTestVirtualService()
+			existing := newPreviewVirtualService(tc.rollout, vs, dsts, pluginConfig)
+			if !tc.withCanary {
+				dsts = nil
+			}
+
+			s.vsclient.EXPECT().GetVirtualService(gomock.Any(), gomock.Eq(previewKey)).Times(1).Return(existing, nil)
+			// CreateVirtualService and PatchVirtualService aren't expected
+			s.vsclient.EXPECT().DeleteVirtualService(gomock.Any(), gomock.Eq(previewKey)).Times(1)
+			s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(2)
+
+			err := s.plugin.syncPreviewVirtualService(s.ctx, tc.rollout, vs, dsts, pluginConfig)
+
+			assert.NoError(s.T(), err)
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/external/envoy/api/v2/cluster"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/options/retries"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OriginalResilienceAnnotation holds outlier detection and circuit breaker settings of a canary Upstream
+// before they were overridden by the plugin
+const OriginalResilienceAnnotation = "glooedge.rollouts.argoproj.io/original-resilience"
+
+// CanaryResilience contains resilience settings that apply to the canary only while a rollout is in progress
+type CanaryResilience struct {
+	// Retry policy of plugin-managed routes that send traffic to the canary only
+	Retries *retries.RetryPolicy
+	// Timeout of plugin-managed routes that send traffic to the canary only
+	Timeout *durationpb.Duration
+	// Outlier detection settings of the canary Upstream
+	OutlierDetection *cluster.OutlierDetection
+	// Circuit breaker settings of the canary Upstream
+	CircuitBreakers *v1.CircuitBreakerConfig
+}
+
+type canaryResilienceJSON struct {
+	Retries          json.RawMessage `json:"retries,omitempty"`
+	Timeout          json.RawMessage `json:"timeout,omitempty"`
+	OutlierDetection json.RawMessage `json:"outlierDetection,omitempty"`
+	CircuitBreakers  json.RawMessage `json:"circuitBreakers,omitempty"`
+}
+
+// UnmarshalJSON unmarshals each of the settings using protobuf JSON mapping
+func (c *CanaryResilience) UnmarshalJSON(b []byte) error {
+	raw := canaryResilienceJSON{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*c = CanaryResilience{}
+	if raw.Retries != nil {
+		c.Retries = &retries.RetryPolicy{}
+		if err := protojson.Unmarshal(raw.Retries, c.Retries); err != nil {
+			return err
+		}
+	}
+	if raw.Timeout != nil {
+		c.Timeout = &durationpb.Duration{}
+		if err := protojson.Unmarshal(raw.Timeout, c.Timeout); err != nil {
+			return err
+		}
+	}
+	if raw.OutlierDetection != nil {
+		c.OutlierDetection = &cluster.OutlierDetection{}
+		if err := protojson.Unmarshal(raw.OutlierDetection, c.OutlierDetection); err != nil {
+			return err
+		}
+	}
+	if raw.CircuitBreakers != nil {
+		c.CircuitBreakers = &v1.CircuitBreakerConfig{}
+		if err := protojson.Unmarshal(raw.CircuitBreakers, c.CircuitBreakers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c CanaryResilience) MarshalJSON() ([]byte, error) {
+	raw := canaryResilienceJSON{}
+	var err error
+	if c.Retries != nil {
+		if raw.Retries, err = protojson.Marshal(c.Retries); err != nil {
+			return nil, err
+		}
+	}
+	if c.Timeout != nil {
+		if raw.Timeout, err = protojson.Marshal(c.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	if c.OutlierDetection != nil {
+		if raw.OutlierDetection, err = protojson.Marshal(c.OutlierDetection); err != nil {
+			return nil, err
+		}
+	}
+	if c.CircuitBreakers != nil {
+		if raw.CircuitBreakers, err = protojson.Marshal(c.CircuitBreakers); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(raw)
+}
+
+// canaryRouteOptions returns a copy of route options with canary retries and timeout applied
+func canaryRouteOptions(options *v1.RouteOptions, resilience *CanaryResilience) *v1.RouteOptions {
+	ret := &v1.RouteOptions{}
+	if options != nil {
+		ret = options.Clone().(*v1.RouteOptions)
+	}
+	if resilience == nil {
+		return ret
+	}
+
+	if resilience.Retries != nil {
+		ret.Retries = resilience.Retries.Clone().(*retries.RetryPolicy)
+	}
+	if resilience.Timeout != nil {
+		ret.Timeout = proto.Clone(resilience.Timeout).(*durationpb.Duration)
+	}
+	return ret
+}
+
+// applyCanaryUpstreamResilience sets outlier detection and circuit breakers of canary Upstreams. The original
+// settings are saved in an annotation of the Upstream, to be restored by restoreCanaryUpstreams.
+func (r *RpcPlugin) applyCanaryUpstreamResilience(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	defaultNamespace string,
+	destinations []destinationPair,
+	resilience *CanaryResilience) error {
+
+	if resilience == nil || (resilience.OutlierDetection == nil && resilience.CircuitBreakers == nil) {
+		return nil
+	}
+
+	checked := map[client.ObjectKey]bool{}
+	for _, dst := range destinations {
+		key := upstreamKey(dst.Canary, defaultNamespace)
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		us, err := r.Client.Upstreams().GetUpstream(ctx, key)
+		if err != nil {
+			return err
+		}
+		original := &v1.Upstream{}
+		us.DeepCopyInto(original)
+
+		if _, saved := us.GetAnnotations()[OriginalResilienceAnnotation]; !saved {
+			settings, err := protojson.Marshal(&v1.UpstreamSpec{
+				OutlierDetection: us.Spec.GetOutlierDetection(),
+				CircuitBreakers:  us.Spec.GetCircuitBreakers(),
+			})
+			if err != nil {
+				return err
+			}
+			if us.Annotations == nil {
+				us.Annotations = map[string]string{}
+			}
+			us.Annotations[OriginalResilienceAnnotation] = string(settings)
+		}
+		if resilience.OutlierDetection != nil {
+			us.Spec.OutlierDetection = resilience.OutlierDetection.Clone().(*cluster.OutlierDetection)
+		}
+		if resilience.CircuitBreakers != nil {
+			us.Spec.CircuitBreakers = resilience.CircuitBreakers.Clone().(*v1.CircuitBreakerConfig)
+		}
+
+		r.LogCtx.Debugf("applying canary resilience settings to Upstream %s for rollout %s/%s", key, rollout.Namespace, rollout.Name)
+		if err = r.Client.Upstreams().PatchUpstream(ctx, us, mergeFromWithOptimisticLock(original)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreCanaryUpstreams restores outlier detection and circuit breakers of canary Upstreams saved
+// by applyCanaryUpstreamResilience
+func (r *RpcPlugin) restoreCanaryUpstreams(ctx context.Context, defaultNamespace string, destinations []destinationPair) error {
+	checked := map[client.ObjectKey]bool{}
+	for _, dst := range destinations {
+		if dst.Canary == nil {
+			continue
+		}
+		key := upstreamKey(dst.Canary, defaultNamespace)
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		us, err := r.Client.Upstreams().GetUpstream(ctx, key)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err != nil {
+			continue
+		}
+		settings, saved := us.GetAnnotations()[OriginalResilienceAnnotation]
+		if !saved {
+			continue
+		}
+
+		originalSettings := &v1.UpstreamSpec{}
+		if err = protojson.Unmarshal([]byte(settings), originalSettings); err != nil {
+			return err
+		}
+		original := &v1.Upstream{}
+		us.DeepCopyInto(original)
+		us.Spec.OutlierDetection = originalSettings.GetOutlierDetection()
+		us.Spec.CircuitBreakers = originalSettings.GetCircuitBreakers()
+		delete(us.Annotations, OriginalResilienceAnnotation)
+
+		r.LogCtx.Debugf("restoring resilience settings of canary Upstream %s", key)
+		if err = r.Client.Upstreams().PatchUpstream(ctx, us, mergeFromWithOptimisticLock(original)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/external/envoy/api/v2/cluster"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/options/retries"
+)
+
+type ResilienceSuite struct {
+	suite.Suite
+	plugin     *RpcPlugin
+	ctrl       *gomock.Controller
+	ctx        context.Context
+	gwclient   *gloov1.MockClientset
+	glooclient *gloomocks.MockClientset
+	usclient   *gloomocks.MockUpstreamClient
+	loggerHook *test.Hook
+}
+
+func (s *ResilienceSuite) SetupTest() {
+	s.ctx = context.TODO()
+	s.ctrl = gomock.NewController(s.T())
+	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	s.usclient = gloomocks.NewMockUpstreamClient(s.ctrl)
+	var testLogger *logrus.Logger
+	testLogger, s.loggerHook = test.NewNullLogger()
+	s.plugin = &RpcPlugin{Client: gloo.NewGlooV1ClientSetFromClientsets(s.gwclient, s.glooclient), LogCtx: testLogger.WithContext(s.ctx)}
+}
+
+func TestResilienceSuite(t *testing.T) {
+	suite.Run(t, new(ResilienceSuite))
+}
+
+func (s *ResilienceSuite) Test_UnmarshalCanaryResilience() {
+	pluginConfig := GlooEdgeTrafficRouting{}
+	err := json.Unmarshal([]byte(`{
+		"canaryResilience": {
+			"retries": {"retryOn": "5xx", "numRetries": 3, "perTryTimeout": "1s"},
+			"timeout": "5s",
+			"outlierDetection": {"consecutive5xx": 2, "baseEjectionTime": "30s"},
+			"circuitBreakers": {"maxConnections": 100}
+		}
+	}`), &pluginConfig)
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "5xx", pluginConfig.CanaryResilience.Retries.GetRetryOn())
+	assert.Equal(s.T(), uint32(3), pluginConfig.CanaryResilience.Retries.GetNumRetries())
+	assert.Equal(s.T(), time.Second, pluginConfig.CanaryResilience.Retries.GetPerTryTimeout().AsDuration())
+	assert.Equal(s.T(), 5*time.Second, pluginConfig.CanaryResilience.Timeout.AsDuration())
+	assert.Equal(s.T(), uint32(2), pluginConfig.CanaryResilience.OutlierDetection.GetConsecutive_5Xx().GetValue())
+	assert.Equal(s.T(), uint32(100), pluginConfig.CanaryResilience.CircuitBreakers.GetMaxConnections().GetValue())
+
+	// round trip
+	b, err := json.Marshal(pluginConfig)
+	assert.NoError(s.T(), err)
+	roundTripped := GlooEdgeTrafficRouting{}
+	assert.NoError(s.T(), json.Unmarshal(b, &roundTripped))
+	assert.True(s.T(), pluginConfig.CanaryResilience.OutlierDetection.Equal(roundTripped.CanaryResilience.OutlierDetection))
+}
+
+func (s *ResilienceSuite) Test_canaryRouteOptions() {
+	routeOptions := &v1.RouteOptions{
+		PrefixRewrite: wrapperspb.String("/"),
+		Timeout:       durationpb.New(time.Minute),
+	}
+	resilience := &CanaryResilience{
+		Retries: &retries.RetryPolicy{RetryOn: "5xx", NumRetries: 2},
+		Timeout: durationpb.New(5 * time.Second),
+	}
+
+	ret := canaryRouteOptions(routeOptions, resilience)
+
+	assert.Equal(s.T(), "/", ret.GetPrefixRewrite().GetValue())
+	assert.Equal(s.T(), 5*time.Second, ret.GetTimeout().AsDuration())
+	assert.Equal(s.T(), uint32(2), ret.GetRetries().GetNumRetries())
+	// original options aren't modified
+	assert.Equal(s.T(), time.Minute, routeOptions.GetTimeout().AsDuration())
+	assert.Nil(s.T(), routeOptions.GetRetries())
+}
+
+func (s *ResilienceSuite) Test_applyCanaryUpstreamResilience_And_restoreCanaryUpstreams() {
+	key := client.ObjectKey{Namespace: "gloo-system", Name: "canarysvc"}
+	canary := &v1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+		Spec: v1.UpstreamSpec{
+			CircuitBreakers: &v1.CircuitBreakerConfig{MaxConnections: wrapperspb.UInt32(1000)},
+		},
+	}
+	original := &v1.Upstream{}
+	canary.DeepCopyInto(original)
+	resilience := &CanaryResilience{
+		OutlierDetection: &cluster.OutlierDetection{Consecutive_5Xx: wrapperspb.UInt32(2)},
+		CircuitBreakers:  &v1.CircuitBreakerConfig{MaxConnections: wrapperspb.UInt32(10)},
+	}
+	dsts := []destinationPair{
+		{Stable: newUpstreamDestination("stablesvc", "", 100), Canary: newUpstreamDestination("canarysvc", "", 0)},
+	}
+
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Eq(key)).Times(2).Return(canary, nil)
+	s.usclient.EXPECT().PatchUpstream(gomock.Any(), gomock.Any(), gomock.Any()).Times(2)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(4)
+
+	err := s.plugin.applyCanaryUpstreamResilience(s.ctx, newTestRollout("stablesvc", "canarysvc"), "gloo-system", dsts, resilience)
+
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), resilience.OutlierDetection.Equal(canary.Spec.GetOutlierDetection()))
+	assert.True(s.T(), resilience.CircuitBreakers.Equal(canary.Spec.GetCircuitBreakers()))
+	assert.Contains(s.T(), canary.GetAnnotations(), OriginalResilienceAnnotation)
+
+	err = s.plugin.restoreCanaryUpstreams(s.ctx, "gloo-system", dsts)
+
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), canary.Spec.GetOutlierDetection())
+	assert.True(s.T(), original.Spec.GetCircuitBreakers().Equal(canary.Spec.GetCircuitBreakers()))
+	assert.NotContains(s.T(), canary.GetAnnotations(), OriginalResilienceAnnotation)
+}
+
+func (s *ResilienceSuite) Test_setWeight_DoesNotApplyResilienceRestoredByRemoveManagedRoutes() {
+	vs := newConflictTestVirtualService()
+	canaryKey := client.ObjectKey{Namespace: "testns", Name: "canarysvc"}
+	// the canary Upstream isn't managed by the plugin, so its destination is kept with 0 weight
+	canary := &v1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   canaryKey.Namespace,
+			Name:        canaryKey.Name,
+			Annotations: map[string]string{OriginalResilienceAnnotation: "{}"},
+		},
+		Spec:   v1.UpstreamSpec{OutlierDetection: &cluster.OutlierDetection{Consecutive_5Xx: wrapperspb.UInt32(2)}},
+		Status: v1.UpstreamStatus{State: v1.UpstreamStatus_Accepted},
+	}
+	vsclient := gloov1.NewMockVirtualServiceClient(s.ctrl)
+	vsclient.EXPECT().GetVirtualService(gomock.Any(), gomock.Any()).Times(2).DoAndReturn(
+		func(context.Context, client.ObjectKey) (*gwv1.VirtualService, error) {
+			return vs.DeepCopy(), nil
+		})
+	// PatchVirtualService isn't expected, the weights don't change
+	s.gwclient.EXPECT().VirtualServices().Return(vsclient).AnyTimes()
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Eq(canaryKey)).AnyTimes().Return(canary, nil)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "stablesvc"})).
+		AnyTimes().Return(acceptedUpstream(), nil)
+	// the restore is the only patch of the canary Upstream
+	s.usclient.EXPECT().PatchUpstream(gomock.Any(), gomock.Any(), gomock.Any()).Times(1)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).AnyTimes()
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	rollout.Status.Abort = true
+	pluginConfig := &GlooEdgeTrafficRouting{
+		VirtualServiceSelector: &DumbObjectSelector{Namespace: "testns", Name: "testvs"},
+		CanaryResilience: &CanaryResilience{
+			OutlierDetection: &cluster.OutlierDetection{Consecutive_5Xx: wrapperspb.UInt32(2)},
+		},
+	}
+
+	assert.NoError(s.T(), s.plugin.removeManagedRoutes(s.ctx, rollout, pluginConfig))
+	assert.NoError(s.T(), s.plugin.setWeight(s.ctx, rollout, 0, pluginConfig))
+
+	assert.Nil(s.T(), canary.Spec.GetOutlierDetection())
+	assert.NotContains(s.T(), canary.GetAnnotations(), OriginalResilienceAnnotation)
+}
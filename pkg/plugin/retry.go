@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"fmt"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// conflictBackoff is used to retry updates failed because resources were changed concurrently
+var conflictBackoff = retry.DefaultBackoff
+
+// mergeFromWithOptimisticLock returns a merge patch that fails with a conflict when the resource was changed
+// since the original was read, instead of overwriting concurrent changes
+func mergeFromWithOptimisticLock(original client.Object) client.Patch {
+	return client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+}
+
+// isConflict returns true when an update failed because resources were changed concurrently, either because of
+// an optimistic lock or because a test operation of a JSON Patch failed
+func isConflict(err error) bool {
+	return k8serrors.IsConflict(err) || isFailedPatchTest(err)
+}
+
+// retryOnConflict runs fn again with backoff while it fails with a conflict. fn must re-read the resources it
+// updates. The number of conflicts is added to the error when retries are exhausted.
+func (r *RpcPlugin) retryOnConflict(fn func() error) error {
+	conflicts := 0
+	err := retry.OnError(conflictBackoff, isConflict, func() error {
+		err := fn()
+		if isConflict(err) {
+			conflicts++
+			r.LogCtx.Debugf("conflict #%d while updating resources, retrying: %s", conflicts, err)
+		}
+		return err
+	})
+	if err != nil && conflicts > 0 {
+		return fmt.Errorf("giving up after %d conflicts: %w", conflicts, err)
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func withConflictBackoff(t *testing.T, backoff wait.Backoff) {
+	original := conflictBackoff
+	conflictBackoff = backoff
+	t.Cleanup(func() { conflictBackoff = original })
+}
+
+func newConflict() error {
+	return k8serrors.NewConflict(schema.GroupResource{Group: "gateway.solo.io", Resource: "virtualservices"}, "vs",
+		errors.New("the object has been modified"))
+}
+
+func Test_retryOnConflict_RetriesUntilSuccess(t *testing.T) {
+	withConflictBackoff(t, wait.Backoff{Steps: 4})
+	logger, _ := test.NewNullLogger()
+	r := &RpcPlugin{LogCtx: logger.WithField("test", t.Name())}
+
+	calls := 0
+	err := r.retryOnConflict(func() error {
+		calls++
+		if calls < 3 {
+			return newConflict()
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func Test_retryOnConflict_ReturnsNumberOfConflicts(t *testing.T) {
+	withConflictBackoff(t, wait.Backoff{Steps: 4})
+	logger, _ := test.NewNullLogger()
+	r := &RpcPlugin{LogCtx: logger.WithField("test", t.Name())}
+
+	err := r.retryOnConflict(newConflict)
+
+	assert.True(t, k8serrors.IsConflict(err))
+	assert.ErrorContains(t, err, "giving up after 4 conflicts")
+}
+
+func Test_retryOnConflict_DoesNotRetryOtherErrors(t *testing.T) {
+	withConflictBackoff(t, wait.Backoff{Steps: 4})
+	logger, _ := test.NewNullLogger()
+	r := &RpcPlugin{LogCtx: logger.WithField("test", t.Name())}
+
+	calls := 0
+	err := r.retryOnConflict(func() error {
+		calls++
+		return errors.New("failed")
+	})
+
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, 1, calls)
+}
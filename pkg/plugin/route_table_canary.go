@@ -6,44 +6,103 @@ import (
 
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
-	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (r *RpcPlugin) handleCanaryUsingRouteTables(
-	ctx context.Context,
-	rollout *v1alpha1.Rollout,
-	desiredWeight int32,
-	pluginConfig *GlooEdgeTrafficRouting) error {
+type routeTableBackend struct {
+	r            *RpcPlugin
+	pluginConfig *GlooEdgeTrafficRouting
+}
 
-	rts, err := r.getRouteTables(ctx, rollout, pluginConfig)
+type routeTableTarget struct {
+	rt       *gwv1.RouteTable
+	original *gwv1.RouteTable
+	dsts     []destinationPair
+}
+
+func (b *routeTableBackend) discover(ctx context.Context, rollout *v1alpha1.Rollout) ([]routingTarget, error) {
+	rts, err := b.r.getRouteTables(ctx, rollout, b.pluginConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	allRouteTablesForCanary, err := r.getDestinationsInRouteTables(rollout, pluginConfig, rts)
+	return b.targetsFrom(rollout, rts)
+}
+
+func (b *routeTableBackend) discoverFrom(rollout *v1alpha1.Rollout, objs []client.Object) ([]routingTarget, error) {
+	rts := make([]*gwv1.RouteTable, len(objs))
+	for i, obj := range objs {
+		rts[i] = obj.(*gwv1.RouteTable)
+	}
+	return b.targetsFrom(rollout, rts)
+}
+
+func (b *routeTableBackend) targetsFrom(rollout *v1alpha1.Rollout, rts []*gwv1.RouteTable) ([]routingTarget, error) {
+	allRouteTablesForCanary, err := b.r.getDestinationsInRouteTables(rollout, b.pluginConfig, rts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	r.maybeConvertSingleToMulti(allRouteTablesForCanary)
-	r.maybeCreateCanaryDestinations(allRouteTablesForCanary, getCanaryServiceName(rollout))
+	ret := make([]routingTarget, len(allRouteTablesForCanary))
+	for i, rt := range allRouteTablesForCanary {
+		original := &gwv1.RouteTable{}
+		rt.RouteTable.DeepCopyInto(original)
+		ret[i] = &routeTableTarget{rt: rt.RouteTable, original: original, dsts: rt.Destinations}
+	}
 
-	for _, rt := range allRouteTablesForCanary {
-		originalRt := &gwv1.RouteTable{}
-		rt.RouteTable.DeepCopyInto(originalRt)
+	return ret, nil
+}
 
-		for _, dst := range rt.Destinations {
-			dst.Stable.Weight = &wrapperspb.UInt32Value{Value: uint32(100 - desiredWeight)}
-			dst.Canary.Weight = &wrapperspb.UInt32Value{Value: uint32(desiredWeight)}
-		}
+func (b *routeTableBackend) patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return b.r.Client.RouteTables().PatchRouteTable(ctx, obj.(*gwv1.RouteTable), patch, opts...)
+}
 
-		if err = r.Client.RouteTables().PatchRouteTable(ctx, rt.RouteTable, client.MergeFrom(originalRt)); err != nil {
-			return err
-		}
+func (b *routeTableBackend) getLive(ctx context.Context, key client.ObjectKey) (client.Object, error) {
+	if b.r.Client.CachedRouteTables() == nil {
+		return nil, nil
+	}
+	rt, err := b.r.Client.RouteTables().GetRouteTable(ctx, key)
+	if err != nil {
+		return nil, err
 	}
+	return rt, nil
+}
+
+func (b *routeTableBackend) gvk() schema.GroupVersionKind {
+	return gwv1.RouteTableGVK
+}
+
+func (b *routeTableBackend) managesUpstreams() bool {
+	return true
+}
+
+func (t *routeTableTarget) object() client.Object {
+	return t.rt
+}
+
+func (t *routeTableTarget) originalObject() client.Object {
+	return t.original
+}
 
-	return nil
+func (t *routeTableTarget) revert() {
+	t.original.Spec.DeepCopyInto(&t.rt.Spec)
+}
+
+func (t *routeTableTarget) namespace() string {
+	return t.rt.GetNamespace()
+}
+
+func (t *routeTableTarget) destinations() []destinationPair {
+	return t.dsts
+}
+
+func (t *routeTableTarget) syncRoutes(update func(routes []*gwv1.Route) []*gwv1.Route) {
+	t.rt.Spec.Routes = update(t.rt.Spec.GetRoutes())
+}
+
+func (t *routeTableTarget) changed() bool {
+	return !t.rt.Spec.Equal(&t.original.Spec)
 }
 
 func (r *RpcPlugin) getDestinationsInRouteTables(
@@ -56,12 +115,15 @@ func (r *RpcPlugin) getDestinationsInRouteTables(
 			continue
 		}
 
-		if len(rt.Spec.GetRoutes()) > 1 && len(pluginConfig.Routes) == 0 {
-			return nil,
-				fmt.Errorf("route table %s/%s has multiple routes but canary config doesn't specify which routes to use", rt.GetNamespace(), rt.GetName())
+		routes := unmanagedRoutes(rt.Spec.GetRoutes())
+		var dsts []destinationPair
+		for _, mapping := range getUpstreamMappings(rollout, pluginConfig) {
+			if len(routes) > 1 && len(mapping.Routes) == 0 {
+				return nil,
+					fmt.Errorf("route table %s/%s has multiple routes but canary config doesn't specify which routes to use", rt.GetNamespace(), rt.GetName())
+			}
+			dsts = append(dsts, r.getMappedDestinationsInRoutes(routes, mapping)...)
 		}
-
-		dsts := r.getDestinationsInRoutes(rt.Spec.GetRoutes(), rollout, pluginConfig)
 		if len(dsts) == 0 {
 			continue
 		}
@@ -97,7 +159,7 @@ func (r *RpcPlugin) getRouteTables(ctx context.Context, rollout *v1alpha1.Rollou
 }
 
 func (r *RpcPlugin) getRouteTable(ctx context.Context, ns, name string) ([]*gwv1.RouteTable, error) {
-	rt, err := r.Client.RouteTables().GetRouteTable(ctx,
+	rt, err := r.routeTableReader().GetRouteTable(ctx,
 		client.ObjectKey{Namespace: ns, Name: name})
 	if err != nil {
 		return nil, err
@@ -106,7 +168,7 @@ func (r *RpcPlugin) getRouteTable(ctx context.Context, ns, name string) ([]*gwv1
 }
 
 func (r *RpcPlugin) listRouteTables(ctx context.Context, ns string, pluginConfig *GlooEdgeTrafficRouting) ([]*gwv1.RouteTable, error) {
-	rts, err := r.Client.RouteTables().ListRouteTable(ctx,
+	rts, err := r.routeTableReader().ListRouteTable(ctx,
 		client.MatchingLabels(pluginConfig.RouteTableSelector.Labels),
 		client.InNamespace(ns))
 	if err != nil {
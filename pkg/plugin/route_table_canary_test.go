@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	"github.com/golang/mock/gomock"
 	"github.com/sirupsen/logrus"
@@ -13,11 +17,13 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/protobuf/types/known/wrapperspb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
 	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
 	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
 	"github.com/solo-io/solo-kit/pkg/api/v1/resources/core"
 )
 
@@ -27,6 +33,8 @@ type RouteTableCanarySuite struct {
 	ctrl       *gomock.Controller
 	ctx        context.Context
 	gwclient   *gloov1.MockClientset
+	glooclient *gloomocks.MockClientset
+	usclient   *gloomocks.MockUpstreamClient
 	rtclient   *gloov1.MockRouteTableClient
 	loggerHook *test.Hook
 }
@@ -35,12 +43,14 @@ func (s *RouteTableCanarySuite) SetupTest() {
 	s.ctx = context.TODO()
 	s.ctrl = gomock.NewController(s.T())
 	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	s.usclient = gloomocks.NewMockUpstreamClient(s.ctrl)
 	s.rtclient = gloov1.NewMockRouteTableClient(s.ctrl)
 	var testLogger *logrus.Logger
 	// see https://github.com/mpchadwick/dbanon/blob/v0.6.0/src/provider_test.go#L39-L42
 	// for example of how to use the hook in tests
 	testLogger, s.loggerHook = test.NewNullLogger()
-	s.plugin = &RpcPlugin{Client: s.gwclient, LogCtx: testLogger.WithContext(s.ctx)}
+	s.plugin = &RpcPlugin{Client: gloo.NewGlooV1ClientSetFromClientsets(s.gwclient, s.glooclient), LogCtx: testLogger.WithContext(s.ctx)}
 }
 
 func TestRouteTableCanarySuite(t *testing.T) {
@@ -299,18 +309,30 @@ func (s *RouteTableCanarySuite) Test_handleCanary_UsingRouteTables() {
 		gomock.Eq(client.MatchingLabels(labels)),
 		gomock.Eq(client.InNamespace(testns))).Times(1).
 		Return(routeTableList, nil)
-	// used in getRouteTable() and handleCanaryUsingRouteTables()
-	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(3)
+	// used in getRouteTable(), applyTargets() dry run and patches
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(5)
+	// used in applyTargets() to validate the patches
+	s.rtclient.EXPECT().PatchRouteTable(
+		gomock.Any(),
+		gomock.Any(),
+		gomock.Any(),
+		gomock.Eq(client.DryRunAll)).Times(len(expectedRts))
 	// used in handleCanaryUsingRouteTables()
 	s.rtclient.EXPECT().PatchRouteTable(
 		gomock.Any(),
-		gomock.Eq(expectedRts[0]),
+		objectEq(expectedRts[0]),
 		gomock.Any()).Times(1)
 
 	s.rtclient.EXPECT().PatchRouteTable(
 		gomock.Any(),
-		gomock.Eq(expectedRts[1]),
+		objectEq(expectedRts[1]),
 		gomock.Any()).Times(1)
+	// used in ensureCanaryUpstreams() and verifyUpstreamsAccepted()
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Name: canarysvc})).Times(4).Return(acceptedUpstream(), nil)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Name: stablesvc})).Times(2).Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(6)
 
 	filterConfig, err := json.Marshal(GlooEdgeTrafficRouting{
 		Routes: []string{route1, route2, route3},
@@ -353,7 +375,7 @@ func (s *RouteTableCanarySuite) Test_handleCanaryUsingRouteTables_ReturnErrorIfG
 	// used in getRouteTable()
 	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(1)
 
-	err := s.plugin.handleCanaryUsingRouteTables(s.ctx,
+	err := s.plugin.setWeight(s.ctx,
 		&v1alpha1.Rollout{
 			Spec: v1alpha1.RolloutSpec{
 				Strategy: v1alpha1.RolloutStrategy{
@@ -384,7 +406,7 @@ func (s *RouteTableCanarySuite) Test_handleCanaryUsingRouteTables_ReturnErrorIfG
 	// used in getRouteTable()
 	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(1)
 
-	err := s.plugin.handleCanaryUsingRouteTables(s.ctx,
+	err := s.plugin.setWeight(s.ctx,
 		&v1alpha1.Rollout{
 			Spec: v1alpha1.RolloutSpec{
 				Strategy: v1alpha1.RolloutStrategy{
@@ -405,3 +427,333 @@ func (s *RouteTableCanarySuite) Test_handleCanaryUsingRouteTables_ReturnErrorIfG
 	assert.Error(s.T(), err)
 	assert.Contains(s.T(), err.Error(), "couldn't find stable services in RouteTables selected")
 }
+
+func newRouteTableTarget(name string, weight uint32) *routeTableTarget {
+	rt := &gwv1.RouteTable{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "testns", Name: name},
+		Spec: gwv1.RouteTableSpec{Routes: []*gwv1.Route{{
+			Name: "route",
+			Action: &gwv1.Route_RouteAction{RouteAction: &v1.RouteAction{
+				Destination: &v1.RouteAction_Multi{Multi: &v1.MultiDestination{
+					Destinations: []*v1.WeightedDestination{newUpstreamDestination("stablesvc", "", 100)},
+				}},
+			}},
+		}}},
+	}
+	original := rt.DeepCopy()
+	rt.Spec.GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()[0].Weight = wrapperspb.UInt32(weight)
+	return &routeTableTarget{rt: rt, original: original}
+}
+
+func (s *RouteTableCanarySuite) Test_applyTargets_RevertsPatchedRouteTables() {
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{}}
+	targets := []routingTarget{newRouteTableTarget("rt1", 70), newRouteTableTarget("rt2", 70), newRouteTableTarget("rt3", 70)}
+
+	var mu sync.Mutex
+	var patched []string
+	s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(8).DoAndReturn(
+		func(_ context.Context, rt *gwv1.RouteTable, _ client.Patch, opts ...client.PatchOption) error {
+			if len(opts) > 0 {
+				return nil
+			}
+			weight := rt.Spec.GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()[0].GetWeight().GetValue()
+			mu.Lock()
+			patched = append(patched, fmt.Sprintf("%s=%d", rt.GetName(), weight))
+			mu.Unlock()
+			if rt.GetName() == "rt3" {
+				return fmt.Errorf("admission webhook denied the request")
+			}
+			return nil
+		})
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(8)
+
+	err := s.plugin.applyTargets(s.ctx, backend, targets, backend.pluginConfig)
+
+	assert.EqualError(s.T(), err, "RouteTable testns/rt3: admission webhook denied the request (reverted: [RouteTable testns/rt1 RouteTable testns/rt2])")
+	assert.ElementsMatch(s.T(), []string{"rt1=70", "rt2=70", "rt3=70", "rt1=100", "rt2=100"}, patched)
+}
+
+func (s *RouteTableCanarySuite) Test_applyTargets_ReportsAllFailedRouteTables() {
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{}}
+	targets := []routingTarget{newRouteTableTarget("rt1", 70), newRouteTableTarget("rt2", 70), newRouteTableTarget("rt3", 70)}
+
+	s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(7).DoAndReturn(
+		func(_ context.Context, rt *gwv1.RouteTable, _ client.Patch, opts ...client.PatchOption) error {
+			if len(opts) == 0 && rt.GetName() != "rt2" {
+				return fmt.Errorf("timeout")
+			}
+			return nil
+		})
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(7)
+
+	err := s.plugin.applyTargets(s.ctx, backend, targets, backend.pluginConfig)
+
+	assert.EqualError(s.T(), err,
+		"RouteTable testns/rt1: timeout; RouteTable testns/rt3: timeout (reverted: [RouteTable testns/rt2])")
+}
+
+func (s *RouteTableCanarySuite) Test_applyTargets_LimitsConcurrency() {
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{PatchConcurrency: 2}}
+	var targets []routingTarget
+	for i := 0; i < 6; i++ {
+		targets = append(targets, newRouteTableTarget(fmt.Sprintf("rt%d", i), 70))
+	}
+
+	var inFlight, maxInFlight int32
+	s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(12).DoAndReturn(
+		func(_ context.Context, _ *gwv1.RouteTable, _ client.Patch, _ ...client.PatchOption) error {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(12)
+
+	err := s.plugin.applyTargets(s.ctx, backend, targets, backend.pluginConfig)
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int32(2), maxInFlight)
+}
+
+func (s *RouteTableCanarySuite) Test_applyTargets_DoesNotPatchWhenValidationFails() {
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{}}
+	targets := []routingTarget{newRouteTableTarget("rt1", 70), newRouteTableTarget("rt2", 70)}
+
+	s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Eq(client.DryRunAll)).Times(2).DoAndReturn(
+		func(_ context.Context, rt *gwv1.RouteTable, _ client.Patch, _ ...client.PatchOption) error {
+			if rt.GetName() == "rt2" {
+				return fmt.Errorf("invalid weight")
+			}
+			return nil
+		})
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(2)
+
+	err := s.plugin.applyTargets(s.ctx, backend, targets, backend.pluginConfig)
+
+	assert.EqualError(s.T(), err, "validation of RouteTable testns/rt2 failed, no resources were changed: invalid weight")
+}
+
+func (s *RouteTableCanarySuite) withInformerCache() *gloov1.MockRouteTableClient {
+	cachedgw := gloov1.NewMockClientset(s.ctrl)
+	cachedrt := gloov1.NewMockRouteTableClient(s.ctrl)
+	cachedgw.EXPECT().RouteTables().Return(cachedrt).AnyTimes()
+	s.plugin.Client = gloo.NewCachedGlooV1ClientSetFromClients(s.gwclient, s.glooclient, nil, nil, cachedgw)
+	return cachedrt
+}
+
+func (s *RouteTableCanarySuite) Test_getRouteTables_ReadsFromInformerCache() {
+	cachedrt := s.withInformerCache()
+	rt := newRouteTableTarget("rt1", 100).rt
+	cachedrt.EXPECT().GetRouteTable(gomock.Any(), client.ObjectKey{Namespace: "testns", Name: "rt1"}).Return(rt, nil)
+
+	rts, err := s.plugin.getRouteTables(s.ctx, newTestRollout("stablesvc", "canarysvc"),
+		&GlooEdgeTrafficRouting{RouteTableSelector: &DumbObjectSelector{Name: "rt1", Namespace: "testns"}})
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []*gwv1.RouteTable{rt}, rts)
+}
+
+// setTestWeight is the change of targets made by refreshStaleTargets tests
+func setTestWeight(weight uint32) func(targets []routingTarget) error {
+	return func(targets []routingTarget) error {
+		for _, target := range targets {
+			target.(*routeTableTarget).rt.Spec.GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()[0].Weight =
+				wrapperspb.UInt32(weight)
+		}
+		return nil
+	}
+}
+
+func (s *RouteTableCanarySuite) Test_refreshStaleTargets_KeepsTargetsWhenInformerCacheIsFresh() {
+	s.withInformerCache()
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{}}
+	target := newRouteTableTarget("rt1", 70)
+	target.rt.ResourceVersion = "1"
+	live := target.original.DeepCopy()
+	live.ResourceVersion = "1"
+
+	s.rtclient.EXPECT().GetRouteTable(gomock.Any(), client.ObjectKey{Namespace: "testns", Name: "rt1"}).Return(live, nil)
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient)
+
+	targets, err := s.plugin.refreshStaleTargets(s.ctx, backend, newTestRollout("stablesvc", "canarysvc"),
+		[]routingTarget{target}, setTestWeight(70))
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []routingTarget{target}, targets)
+}
+
+func (s *RouteTableCanarySuite) Test_refreshStaleTargets_ReadsChangedRouteTablesOnly() {
+	s.withInformerCache()
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{}}
+	unchanged := newRouteTableTarget("rt1", 100)
+	changed := newRouteTableTarget("rt2", 70)
+	changed.rt.ResourceVersion = "1"
+	live := changed.original.DeepCopy()
+	live.ResourceVersion = "1"
+
+	// up-to-date RouteTables aren't read from the API server
+	s.rtclient.EXPECT().GetRouteTable(gomock.Any(), client.ObjectKey{Namespace: "testns", Name: "rt2"}).Return(live, nil)
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient)
+
+	targets, err := s.plugin.refreshStaleTargets(s.ctx, backend, newTestRollout("stablesvc", "canarysvc"),
+		[]routingTarget{unchanged, changed}, setTestWeight(70))
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []routingTarget{unchanged, changed}, targets)
+}
+
+func (s *RouteTableCanarySuite) Test_refreshStaleTargets_UsesLiveRouteTablesWhenInformerCacheIsStale() {
+	s.withInformerCache()
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{}}
+	target := newRouteTableTarget("rt1", 70)
+	target.rt.ResourceVersion = "1"
+	live := target.original.DeepCopy()
+	live.ResourceVersion = "2"
+	live.Spec.GetRoutes()[0].GetRouteAction().GetMulti().Destinations = append(
+		live.Spec.GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations(), newUpstreamDestination("canarysvc", "", 0))
+
+	s.rtclient.EXPECT().GetRouteTable(gomock.Any(), client.ObjectKey{Namespace: "testns", Name: "rt1"}).Return(live, nil)
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient)
+
+	targets, err := s.plugin.refreshStaleTargets(s.ctx, backend, newTestRollout("stablesvc", "canarysvc"),
+		[]routingTarget{target}, setTestWeight(70))
+
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), targets, 1)
+	assert.Same(s.T(), live, targets[0].object())
+	assert.Equal(s.T(), "2", targets[0].originalObject().GetResourceVersion())
+	assert.Equal(s.T(), "canarysvc", targets[0].destinations()[0].Canary.GetDestination().GetUpstream().GetName())
+	// the changes are computed again from the live RouteTable
+	assert.True(s.T(), targets[0].changed())
+	assert.Equal(s.T(), uint32(70), targets[0].destinations()[0].Stable.GetWeight().GetValue())
+}
+
+func (s *RouteTableCanarySuite) Test_refreshStaleTargets_DoesNothingWithoutInformerCache() {
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{}}
+	target := newRouteTableTarget("rt1", 70)
+
+	targets, err := s.plugin.refreshStaleTargets(s.ctx, backend, newTestRollout("stablesvc", "canarysvc"),
+		[]routingTarget{target}, setTestWeight(70))
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []routingTarget{target}, targets)
+}
+
+func (s *RouteTableCanarySuite) Test_applyTargets_SkipsUnchangedRouteTables() {
+	s.plugin.LogCtx.Logger.SetLevel(logrus.DebugLevel)
+	backend := &routeTableBackend{r: s.plugin, pluginConfig: &GlooEdgeTrafficRouting{}}
+	unchanged := newRouteTableTarget("rt1", 100)
+	changed := newRouteTableTarget("rt2", 70)
+
+	s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), changed.rt, gomock.Any()).Return(nil)
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient)
+
+	err := s.plugin.applyTargets(s.ctx, backend, []routingTarget{unchanged, changed}, backend.pluginConfig)
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "skipping patch of RouteTable testns/rt1, it's already up to date", s.loggerHook.LastEntry().Message)
+}
+
+func (s *RouteTableCanarySuite) Test_SetWeight_RetriesOnConflict() {
+	rt := newRouteTableTarget("rt1", 100).original
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	rollout.Spec.Strategy.Canary.TrafficRouting = &v1alpha1.RolloutTrafficRouting{
+		Plugins: map[string]json.RawMessage{
+			PluginName: []byte(`{"routeTable": {"name": "rt1", "namespace": "testns"}}`),
+		},
+	}
+
+	// the RouteTable is read again after the conflict
+	s.rtclient.EXPECT().GetRouteTable(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "rt1"})).Times(2).DoAndReturn(
+		func(context.Context, client.ObjectKey) (*gwv1.RouteTable, error) {
+			return rt.DeepCopy(), nil
+		})
+	gomock.InOrder(
+		s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), gomock.Any(), gomock.Any()).Return(newConflict()),
+		s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ context.Context, rt *gwv1.RouteTable, _ client.Patch, _ ...client.PatchOption) error {
+				dsts := rt.Spec.GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()
+				assert.Len(s.T(), dsts, 2)
+				assert.Equal(s.T(), uint32(90), dsts[0].GetWeight().GetValue())
+				assert.Equal(s.T(), uint32(10), dsts[1].GetWeight().GetValue())
+				return nil
+			}),
+	)
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(4)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Any()).AnyTimes().Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).AnyTimes()
+
+	err := s.plugin.SetWeight(rollout, 10, nil)
+
+	assert.Empty(s.T(), err.ErrorString)
+}
+
+func (s *RouteTableCanarySuite) Test_setWeight_SkipsPatchOfRouteTablesWithUpToDateWeights() {
+	upToDate := newRouteTableTarget("rt1", 70).rt
+	dsts := upToDate.Spec.GetRoutes()[0].GetRouteAction().GetMulti()
+	dsts.Destinations = append(dsts.GetDestinations(), newUpstreamDestination("canarysvc", "", 30))
+	stale := newRouteTableTarget("rt2", 100).rt
+	labels := map[string]string{"app": "echo"}
+	list := &gwv1.RouteTableList{Items: make([]gwv1.RouteTable, 2)}
+	upToDate.DeepCopyInto(&list.Items[0])
+	stale.DeepCopyInto(&list.Items[1])
+
+	s.rtclient.EXPECT().ListRouteTable(gomock.Any(), gomock.Eq(client.MatchingLabels(labels)), gomock.Eq(client.InNamespace("testns"))).
+		Times(1).Return(list, nil)
+	// only rt2 is patched, without a dry run since it's the only changed RouteTable
+	s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).DoAndReturn(
+		func(_ context.Context, rt *gwv1.RouteTable, _ client.Patch, opts ...client.PatchOption) error {
+			assert.Equal(s.T(), "rt2", rt.GetName())
+			assert.Empty(s.T(), opts)
+			return nil
+		})
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(2)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Any()).AnyTimes().Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).AnyTimes()
+
+	err := s.plugin.setWeight(s.ctx, newTestRollout("stablesvc", "canarysvc"), 30, &GlooEdgeTrafficRouting{
+		RouteTableSelector: &DumbObjectSelector{Namespace: "testns", Labels: labels},
+	})
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *RouteTableCanarySuite) Test_setWeight_ReadsOnlyPatchedRouteTablesFromAPIServer() {
+	cachedrt := s.withInformerCache()
+	upToDate := newRouteTableTarget("rt1", 70).rt
+	dsts := upToDate.Spec.GetRoutes()[0].GetRouteAction().GetMulti()
+	dsts.Destinations = append(dsts.GetDestinations(), newUpstreamDestination("canarysvc", "", 30))
+	upToDate.ResourceVersion = "1"
+	stale := newRouteTableTarget("rt2", 100).rt
+	stale.ResourceVersion = "1"
+	labels := map[string]string{"app": "echo"}
+	list := &gwv1.RouteTableList{Items: make([]gwv1.RouteTable, 2)}
+	upToDate.DeepCopyInto(&list.Items[0])
+	stale.DeepCopyInto(&list.Items[1])
+
+	cachedrt.EXPECT().ListRouteTable(gomock.Any(), gomock.Eq(client.MatchingLabels(labels)), gomock.Eq(client.InNamespace("testns"))).
+		Times(1).Return(list, nil)
+	// rt1 is up to date, so it isn't read from the API server
+	s.rtclient.EXPECT().GetRouteTable(gomock.Any(), client.ObjectKey{Namespace: "testns", Name: "rt2"}).Times(1).Return(stale.DeepCopy(), nil)
+	s.rtclient.EXPECT().PatchRouteTable(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).DoAndReturn(
+		func(_ context.Context, rt *gwv1.RouteTable, _ client.Patch, _ ...client.PatchOption) error {
+			assert.Equal(s.T(), "rt2", rt.GetName())
+			return nil
+		})
+	s.gwclient.EXPECT().RouteTables().Return(s.rtclient).Times(2)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Any()).AnyTimes().Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).AnyTimes()
+
+	err := s.plugin.setWeight(s.ctx, newTestRollout("stablesvc", "canarysvc"), 30, &GlooEdgeTrafficRouting{
+		RouteTableSelector: &DumbObjectSelector{Namespace: "testns", Labels: labels},
+	})
+
+	assert.NoError(s.T(), err)
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	fedgwv1 "github.com/solo-io/solo-apis/pkg/api/fed.gateway.solo.io/v1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FieldManager is the field manager of fields written by the plugin with server-side apply
+const FieldManager = "argo-rollouts-glooedge"
+
+// appliedFields are the paths of the fields applied by the plugin in each kind of routing resources. Gloo and Gateway
+// API CRDs don't declare keys of lists, so lists are atomic, and the plugin applies the outermost lists enclosing
+// the weights, destinations and routes it writes. The same fields are applied on every update, so that the plugin
+// never gives up the ownership of lists it applied before.
+var appliedFields = map[schema.GroupVersionKind][][]string{
+	gwv1.VirtualServiceGVK: {{"spec", "virtualHost", "routes"}},
+	gwv1.RouteTableGVK:     {{"spec", "routes"}},
+	gwv1.GatewayGVK: {
+		{"spec", "tcpGateway", "tcpHosts"},
+		{"spec", "hybridGateway", "matchedGateways"},
+	},
+	fedgwv1.FederatedVirtualServiceGVK: {{"spec", "template", "spec", "virtualHost", "routes"}},
+	fedgwv1.FederatedRouteTableGVK:     {{"spec", "template", "spec", "routes"}},
+	platformRouteTableKind.GVK:         {platformRouteTableKind.RoutesPath},
+	httpRouteKind.GVK:                  {httpRouteKind.RoutesPath},
+}
+
+// applyPatch is a server-side apply patch with the fields of the object being patched that are written by the
+// plugin, see appliedFields. Metadata other than the name, namespace and resourceVersion is left out, so that the
+// plugin doesn't take ownership of labels, annotations etc. The resourceVersion makes the patch fail with a conflict
+// when the resource was changed since it was read.
+type applyPatch struct {
+	gvk schema.GroupVersionKind
+}
+
+func (p *applyPatch) Type() types.PatchType {
+	return types.ApplyPatchType
+}
+
+func (p *applyPatch) Data(obj client.Object) ([]byte, error) {
+	paths, found := appliedFields[p.gvk]
+	if !found {
+		return nil, fmt.Errorf("server-side apply isn't supported for %s", p.gvk.Kind)
+	}
+
+	var fields interface{}
+	if err := roundTripJSON(obj, &fields); err != nil {
+		return nil, err
+	}
+	fieldsObj, ok := fields.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s/%s isn't a JSON object", p.gvk.Kind, obj.GetNamespace(), obj.GetName())
+	}
+
+	metadata := map[string]interface{}{
+		"name":      obj.GetName(),
+		"namespace": obj.GetNamespace(),
+	}
+	if obj.GetResourceVersion() != "" {
+		metadata["resourceVersion"] = obj.GetResourceVersion()
+	}
+	applied := map[string]interface{}{
+		"apiVersion": p.gvk.GroupVersion().String(),
+		"kind":       p.gvk.Kind,
+		"metadata":   metadata,
+	}
+
+	hasFields := false
+	for _, path := range paths {
+		value, found, err := unstructured.NestedFieldNoCopy(fieldsObj, path...)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		if err = unstructured.SetNestedField(applied, value, path...); err != nil {
+			return nil, err
+		}
+		hasFields = true
+	}
+	if !hasFields {
+		return nil, fmt.Errorf("%s %s/%s has no routes to apply", p.gvk.Kind, obj.GetNamespace(), obj.GetName())
+	}
+
+	return json.Marshal(applied)
+}
+
+// routingPatch returns the patch used to update a routing resource changed since the original was read. Resources
+// are updated with server-side apply when it's enabled in plugin configuration, and with a JSON Patch otherwise.
+func routingPatch(
+	original client.Object,
+	gvk schema.GroupVersionKind,
+	pluginConfig *GlooEdgeTrafficRouting) (client.Patch, []client.PatchOption) {
+
+	if pluginConfig.ServerSideApply {
+		return &applyPatch{gvk: gvk}, []client.PatchOption{client.ForceOwnership, client.FieldOwner(FieldManager)}
+	}
+	return jsonPatchFrom(original), nil
+}
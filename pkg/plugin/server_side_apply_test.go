@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+)
+
+func Test_applyPatch_AppliesFieldsWrittenByPlugin(t *testing.T) {
+	vs := &gwv1.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "gloo-system",
+			Name:            "vs",
+			Labels:          map[string]string{"app": "echo"},
+			Annotations:     map[string]string{"note": "value"},
+			ResourceVersion: "42",
+		},
+		Spec: gwv1.VirtualServiceSpec{
+			VirtualHost: &gwv1.VirtualHost{
+				Domains: []string{"echo.example.com"},
+				Routes:  []*gwv1.Route{newSingleUpstreamRoute("echo", "stablesvc")},
+			},
+			DisplayName: "echo",
+		},
+	}
+
+	patch, opts := routingPatch(vs.DeepCopy(), gwv1.VirtualServiceGVK, &GlooEdgeTrafficRouting{ServerSideApply: true})
+	data, err := patch.Data(vs)
+
+	assert.NoError(t, err)
+	assert.Equal(t, types.ApplyPatchType, patch.Type())
+	assert.Equal(t, []client.PatchOption{client.ForceOwnership, client.FieldOwner(FieldManager)}, opts)
+	assert.JSONEq(t, `{
+		"apiVersion": "gateway.solo.io/v1",
+		"kind": "VirtualService",
+		"metadata": {"name": "vs", "namespace": "gloo-system", "resourceVersion": "42"},
+		"spec": {
+			"virtualHost": {
+				"routes": [{"name": "echo", "routeAction": {"single": {"upstream": {"name": "stablesvc"}}}}]
+			}
+		}
+	}`, string(data))
+}
+
+func Test_applyPatch_AppliesListsPresentInResource(t *testing.T) {
+	gw := &gwv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "gloo-system", Name: "gw"},
+		Spec: gwv1.GatewaySpec{
+			BindPort: 8000,
+			GatewayType: &gwv1.GatewaySpec_TcpGateway{TcpGateway: &gwv1.TcpGateway{
+				TcpHosts: []*v1.TcpHost{newTcpHost("tcp", "stablesvc")},
+			}},
+		},
+	}
+
+	data, err := (&applyPatch{gvk: gwv1.GatewayGVK}).Data(gw)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"apiVersion": "gateway.solo.io/v1",
+		"kind": "Gateway",
+		"metadata": {"name": "gw", "namespace": "gloo-system"},
+		"spec": {
+			"tcpGateway": {
+				"tcpHosts": [{"name": "tcp", "destination": {"single": {"upstream": {"name": "stablesvc"}}}}]
+			}
+		}
+	}`, string(data))
+}
+
+func Test_applyPatch_ReturnsErrors(t *testing.T) {
+	_, err := (&applyPatch{gvk: gwv1.VirtualServiceGVK}).Data(&gwv1.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "gloo-system", Name: "vs"},
+	})
+	assert.EqualError(t, err, "VirtualService gloo-system/vs has no routes to apply")
+
+	_, err = (&applyPatch{gvk: gwv1.MatchableHttpGatewayGVK}).Data(&gwv1.MatchableHttpGateway{})
+	assert.EqualError(t, err, "server-side apply isn't supported for MatchableHttpGateway")
+}
+
+func Test_routingPatch_DefaultsToJSONPatch(t *testing.T) {
+	patch, opts := routingPatch(&gwv1.VirtualService{}, gwv1.VirtualServiceGVK, &GlooEdgeTrafficRouting{})
+
+	assert.Equal(t, types.JSONPatchType, patch.Type())
+	assert.Empty(t, opts)
+}
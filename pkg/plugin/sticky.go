@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/core/matchers"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/options/headers"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type StickySessions struct {
+	// The name of the cookie that pins clients to the canary, defaults to `<rollout name>-canary`
+	CookieName string `json:"cookieName" protobuf:"bytes,1,name=cookieName"`
+}
+
+func stickyCookieName(rollout *v1alpha1.Rollout, sticky *StickySessions) string {
+	if sticky.CookieName != "" {
+		return sticky.CookieName
+	}
+	return rollout.Name + "-canary"
+}
+
+// stickyCookieValue identifies the canary release, so that cookies set for a previous canary don't match
+func stickyCookieValue(rollout *v1alpha1.Rollout) string {
+	if rollout.Status.CurrentPodHash != "" {
+		return rollout.Status.CurrentPodHash
+	}
+	return "canary"
+}
+
+// stickyCookieOptions returns options that make the canary set the sticky session cookie
+func stickyCookieOptions(rollout *v1alpha1.Rollout, sticky *StickySessions) *v1.WeightedDestinationOptions {
+	return &v1.WeightedDestinationOptions{
+		HeaderManipulation: &headers.HeaderManipulation{
+			ResponseHeadersToAdd: []*headers.HeaderValueOption{
+				{
+					Header: &headers.HeaderValue{
+						Key:   "Set-Cookie",
+						Value: fmt.Sprintf("%s=%s; Path=/", stickyCookieName(rollout, sticky), stickyCookieValue(rollout)),
+					},
+					Append: wrapperspb.Bool(true),
+				},
+			},
+		},
+	}
+}
+
+func stickyRoutePrefix(rollout *v1alpha1.Rollout) string {
+	return fmt.Sprintf("%ssticky.%s.%s.", ManagedRoutePrefix, rollout.Namespace, rollout.Name)
+}
+
+// syncStickyRoutes returns routes with sticky session routes of the rollout updated. A sticky session route is
+// created in front of every route with destinations, it matches requests with the sticky session cookie and
+// sends them to the canary. Sticky session routes are removed when they are disabled or when the canary
+// doesn't receive any traffic.
+func (r *RpcPlugin) syncStickyRoutes(
+	routes []*gwv1.Route,
+	destinations []destinationPair,
+	rollout *v1alpha1.Rollout,
+	desiredWeight int32,
+	pluginConfig *GlooEdgeTrafficRouting) []*gwv1.Route {
+
+	prefix := stickyRoutePrefix(rollout)
+	ret := make([]*gwv1.Route, 0, len(routes))
+	for _, route := range routes {
+		if strings.HasPrefix(route.GetName(), prefix) {
+			continue
+		}
+
+		if pluginConfig.StickySessions != nil && desiredWeight > 0 {
+			for _, dst := range destinations {
+				if dst.DestinationsParent == route.GetRouteAction() {
+					ret = append(ret, newStickyRoute(route, dst, rollout, pluginConfig))
+					break
+				}
+			}
+		}
+		ret = append(ret, route)
+	}
+
+	return ret
+}
+
+func newStickyRoute(
+	route *gwv1.Route,
+	dst destinationPair,
+	rollout *v1alpha1.Rollout,
+	pluginConfig *GlooEdgeTrafficRouting) *gwv1.Route {
+
+	cookieMatcher := &matchers.HeaderMatcher{
+		Name: "Cookie",
+		Value: fmt.Sprintf("(.*;\\s*)?%s=%s(;.*)?",
+			regexp.QuoteMeta(stickyCookieName(rollout, pluginConfig.StickySessions)), regexp.QuoteMeta(stickyCookieValue(rollout))),
+		Regex: true,
+	}
+
+	ret := route.Clone().(*gwv1.Route)
+	ret.Name = stickyRoutePrefix(rollout) + route.GetName()
+	if len(ret.GetMatchers()) == 0 {
+		ret.Matchers = []*matchers.Matcher{{PathSpecifier: &matchers.Matcher_Prefix{Prefix: "/"}}}
+	}
+	for _, m := range ret.GetMatchers() {
+		m.Headers = append(m.Headers, cookieMatcher.Clone().(*matchers.HeaderMatcher))
+	}
+
+	routeAction := dst.DestinationsParent.Clone().(*v1.RouteAction)
+	routeAction.Destination = &v1.RouteAction_Single{
+		Single: dst.Canary.GetDestination().Clone().(*v1.Destination),
+	}
+	ret.Action = &gwv1.Route_RouteAction{RouteAction: routeAction}
+	ret.Options = canaryRouteOptions(route.GetOptions(), pluginConfig.CanaryResilience)
+
+	return ret
+}
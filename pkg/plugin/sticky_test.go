@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/core/matchers"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+)
+
+type StickySuite struct {
+	suite.Suite
+	plugin     *RpcPlugin
+	ctrl       *gomock.Controller
+	ctx        context.Context
+	gwclient   *gloov1.MockClientset
+	glooclient *gloomocks.MockClientset
+	loggerHook *test.Hook
+}
+
+func (s *StickySuite) SetupTest() {
+	s.ctx = context.TODO()
+	s.ctrl = gomock.NewController(s.T())
+	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	var testLogger *logrus.Logger
+	testLogger, s.loggerHook = test.NewNullLogger()
+	s.plugin = &RpcPlugin{Client: gloo.NewGlooV1ClientSetFromClientsets(s.gwclient, s.glooclient), LogCtx: testLogger.WithContext(s.ctx)}
+}
+
+func TestStickySuite(t *testing.T) {
+	suite.Run(t, new(StickySuite))
+}
+
+func newStickyTestRoutes() ([]*gwv1.Route, []destinationPair) {
+	routeAction := &v1.RouteAction{
+		Destination: &v1.RouteAction_Multi{
+			Multi: &v1.MultiDestination{
+				Destinations: []*v1.WeightedDestination{
+					newUpstreamDestination("stablesvc", "", 90),
+					newUpstreamDestination("canarysvc", "", 10),
+				},
+			},
+		},
+	}
+	routes := []*gwv1.Route{
+		{
+			Name:     "route1",
+			Matchers: []*matchers.Matcher{{PathSpecifier: &matchers.Matcher_Prefix{Prefix: "/api"}}},
+			Action:   &gwv1.Route_RouteAction{RouteAction: routeAction},
+		},
+		{
+			Name: "route2",
+			Action: &gwv1.Route_DirectResponseAction{
+				DirectResponseAction: &v1.DirectResponseAction{Status: 404},
+			},
+		},
+	}
+	dsts := []destinationPair{
+		{
+			DestinationsParent: routeAction,
+			Stable:             routeAction.GetMulti().GetDestinations()[0],
+			Canary:             routeAction.GetMulti().GetDestinations()[1],
+		},
+	}
+	return routes, dsts
+}
+
+func (s *StickySuite) Test_syncStickyRoutes() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	rollout.Status.CurrentPodHash = "abc123"
+	pluginConfig := &GlooEdgeTrafficRouting{
+		StickySessions:   &StickySessions{},
+		CanaryResilience: &CanaryResilience{Timeout: durationpb.New(5 * time.Second)},
+	}
+	routes, dsts := newStickyTestRoutes()
+
+	ret := s.plugin.syncStickyRoutes(routes, dsts, rollout, 10, pluginConfig)
+
+	assert.Len(s.T(), ret, 3)
+	sticky := ret[0]
+	assert.Equal(s.T(), "argo-rollouts-glooedge.sticky.rollout-ns.rollout.route1", sticky.GetName())
+	assert.True(s.T(), isManagedRoute(sticky))
+	assert.Equal(s.T(), "/api", sticky.GetMatchers()[0].GetPrefix())
+	assert.Len(s.T(), sticky.GetMatchers()[0].GetHeaders(), 1)
+	assert.Equal(s.T(), "Cookie", sticky.GetMatchers()[0].GetHeaders()[0].GetName())
+	assert.Equal(s.T(), `(.*;\s*)?rollout-canary=abc123(;.*)?`, sticky.GetMatchers()[0].GetHeaders()[0].GetValue())
+	assert.Equal(s.T(), "canarysvc", sticky.GetRouteAction().GetSingle().GetUpstream().GetName())
+	assert.Equal(s.T(), 5*time.Second, sticky.GetOptions().GetTimeout().AsDuration())
+	assert.Same(s.T(), routes[0], ret[1])
+	assert.Same(s.T(), routes[1], ret[2])
+	// original route isn't modified
+	assert.Empty(s.T(), routes[0].GetMatchers()[0].GetHeaders())
+
+	// syncing again doesn't duplicate sticky routes
+	ret = s.plugin.syncStickyRoutes(ret, dsts, rollout, 20, pluginConfig)
+	assert.Len(s.T(), ret, 3)
+
+	// sticky routes are removed when the canary doesn't receive traffic
+	ret = s.plugin.syncStickyRoutes(ret, dsts, rollout, 0, pluginConfig)
+	assert.Equal(s.T(), routes, ret)
+}
+
+func (s *StickySuite) Test_syncStickyRoutes_Disabled() {
+	routes, dsts := newStickyTestRoutes()
+
+	ret := s.plugin.syncStickyRoutes(routes, dsts, newTestRollout("stablesvc", "canarysvc"), 10, &GlooEdgeTrafficRouting{})
+
+	assert.Equal(s.T(), routes, ret)
+}
+
+func (s *StickySuite) Test_getCanaryDestinationOptions() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	pluginConfig := &GlooEdgeTrafficRouting{}
+	err := json.Unmarshal([]byte(`{
+		"stickySessions": {"cookieName": "pinned"},
+		"canaryDestinationOptions": {
+			"headerManipulation": {"requestHeadersToAdd": [{"header": {"key": "x-canary", "value": "true"}}]}
+		}
+	}`), pluginConfig)
+	assert.NoError(s.T(), err)
+
+	ret := getCanaryDestinationOptions(rollout, pluginConfig)
+
+	assert.Equal(s.T(), "x-canary", ret.GetHeaderManipulation().GetRequestHeadersToAdd()[0].GetHeader().GetKey())
+	assert.Equal(s.T(), "Set-Cookie", ret.GetHeaderManipulation().GetResponseHeadersToAdd()[0].GetHeader().GetKey())
+	assert.Equal(s.T(), "pinned=canary; Path=/", ret.GetHeaderManipulation().GetResponseHeadersToAdd()[0].GetHeader().GetValue())
+	// plugin configuration isn't modified
+	assert.Empty(s.T(), pluginConfig.CanaryDestinationOptions.GetHeaderManipulation().GetResponseHeadersToAdd())
+
+	assert.Nil(s.T(), getCanaryDestinationOptions(rollout, &GlooEdgeTrafficRouting{}))
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"os"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Environment variables setting timeouts of API calls for all Rollouts, e.g. `15s`
+const (
+	GetTimeoutEnv   = "GLOOEDGE_PLUGIN_GET_TIMEOUT"
+	ListTimeoutEnv  = "GLOOEDGE_PLUGIN_LIST_TIMEOUT"
+	PatchTimeoutEnv = "GLOOEDGE_PLUGIN_PATCH_TIMEOUT"
+)
+
+// defaultAPITimeouts are used when timeouts are set neither in plugin configuration nor in environment variables
+var defaultAPITimeouts = gloo.Timeouts{
+	Get:   10 * time.Second,
+	List:  30 * time.Second,
+	Patch: 10 * time.Second,
+}
+
+// APITimeouts are timeouts of single API calls made by the plugin
+type APITimeouts struct {
+	// Timeout of reads of single resources
+	Get *metav1.Duration `json:"get" protobuf:"bytes,1,name=get"`
+	// Timeout of lists of resources, e.g. RouteTables selected by labels
+	List *metav1.Duration `json:"list" protobuf:"bytes,2,name=list"`
+	// Timeout of patches and other writes
+	Patch *metav1.Duration `json:"patch" protobuf:"bytes,3,name=patch"`
+}
+
+// getAPITimeouts returns the timeouts from plugin configuration, environment variables or defaults, in this order
+func (r *RpcPlugin) getAPITimeouts(pluginConfig *GlooEdgeTrafficRouting) gloo.Timeouts {
+	timeouts := gloo.Timeouts{
+		Get:   r.envTimeout(GetTimeoutEnv, defaultAPITimeouts.Get),
+		List:  r.envTimeout(ListTimeoutEnv, defaultAPITimeouts.List),
+		Patch: r.envTimeout(PatchTimeoutEnv, defaultAPITimeouts.Patch),
+	}
+
+	if configured := pluginConfig.APITimeouts; configured != nil {
+		if configured.Get != nil {
+			timeouts.Get = configured.Get.Duration
+		}
+		if configured.List != nil {
+			timeouts.List = configured.List.Duration
+		}
+		if configured.Patch != nil {
+			timeouts.Patch = configured.Patch.Duration
+		}
+	}
+
+	return timeouts
+}
+
+func (r *RpcPlugin) envTimeout(env string, defaultTimeout time.Duration) time.Duration {
+	value, found := os.LookupEnv(env)
+	if !found || value == "" {
+		return defaultTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		r.LogCtx.Warnf("ignoring invalid %s=%s: %s", env, value, err)
+		return defaultTimeout
+	}
+	return timeout
+}
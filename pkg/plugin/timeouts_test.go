@@ -0,0 +1,33 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_getAPITimeouts_Defaults(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	r := &RpcPlugin{LogCtx: logger.WithField("test", t.Name())}
+
+	assert.Equal(t, defaultAPITimeouts, r.getAPITimeouts(&GlooEdgeTrafficRouting{}))
+}
+
+func Test_getAPITimeouts_ConfigOverridesEnvironment(t *testing.T) {
+	t.Setenv(GetTimeoutEnv, "5s")
+	t.Setenv(ListTimeoutEnv, "1m")
+	t.Setenv(PatchTimeoutEnv, "invalid")
+	logger, hook := test.NewNullLogger()
+	r := &RpcPlugin{LogCtx: logger.WithField("test", t.Name())}
+
+	timeouts := r.getAPITimeouts(&GlooEdgeTrafficRouting{
+		APITimeouts: &APITimeouts{List: &metav1.Duration{Duration: 45 * time.Second}},
+	})
+
+	assert.Equal(t, gloo.Timeouts{Get: 5 * time.Second, List: 45 * time.Second, Patch: defaultAPITimeouts.Patch}, timeouts)
+	assert.Equal(t, `ignoring invalid GLOOEDGE_PLUGIN_PATCH_TIMEOUT=invalid: time: invalid duration "invalid"`, hook.LastEntry().Message)
+}
@@ -0,0 +1,325 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
+	"golang.org/x/exp/slices"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// unstructuredRouteKind describes where routes and their Service destinations are in resources that don't
+// have typed clients, e.g. Gloo Platform RouteTables and Gateway API HTTPRoutes
+type unstructuredRouteKind struct {
+	// The name of the kind used in messages
+	Name string
+	GVK  schema.GroupVersionKind
+	// The path of the list of routes in the resource
+	RoutesPath []string
+	// The path of the list of destinations in a route
+	DestinationsPath []string
+	// The path of the Service reference in a destination, `name` and `namespace` fields of the reference are used
+	RefPath []string
+}
+
+// unstructuredDestinationPair holds stable and canary destinations of a route, same as destinationPair. The
+// destinations are the maps of the resource, so changing them changes the resource.
+type unstructuredDestinationPair struct {
+	Route   map[string]interface{}
+	Stable  map[string]interface{}
+	Canary  map[string]interface{}
+	Mapping *UpstreamMapping
+}
+
+// unstructuredBackend is the routingBackend of resources of an unstructuredRouteKind. Their destinations point to
+// Services, so Upstreams aren't managed and the weights are set by the targets themselves, see weightedTarget.
+type unstructuredBackend struct {
+	r            *RpcPlugin
+	pluginConfig *GlooEdgeTrafficRouting
+	kind         *unstructuredRouteKind
+	selector     *DumbObjectSelector
+}
+
+type unstructuredTarget struct {
+	kind     *unstructuredRouteKind
+	obj      *unstructured.Unstructured
+	original *unstructured.Unstructured
+	dsts     []unstructuredDestinationPair
+}
+
+func (b *unstructuredBackend) discover(ctx context.Context, rollout *v1alpha1.Rollout) ([]routingTarget, error) {
+	objs, err := b.r.getUnstructured(ctx, b.kind, b.selector, rollout)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []routingTarget
+	for _, obj := range objs {
+		original := obj.DeepCopy()
+		dsts, err := getUnstructuredDestinations(b.kind, obj, rollout, b.pluginConfig)
+		if err != nil {
+			return nil, err
+		}
+		if len(dsts) == 0 {
+			continue
+		}
+		ret = append(ret, &unstructuredTarget{kind: b.kind, obj: obj, original: original, dsts: dsts})
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("couldn't find stable services in %ss selected with Name: '%s', Namespace: '%s', Labels: %v, with route names in %v",
+			b.kind.Name, b.selector.Name, b.selector.Namespace, b.selector.Labels, b.pluginConfig.Routes)
+	}
+
+	return ret, nil
+}
+
+func (b *unstructuredBackend) patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return b.r.Client.Unstructured().Patch(ctx, obj, patch, opts...)
+}
+
+func (b *unstructuredBackend) gvk() schema.GroupVersionKind {
+	return b.kind.GVK
+}
+
+func (b *unstructuredBackend) managesUpstreams() bool {
+	return false
+}
+
+func (t *unstructuredTarget) object() client.Object {
+	return t.obj
+}
+
+func (t *unstructuredTarget) originalObject() client.Object {
+	return t.original
+}
+
+func (t *unstructuredTarget) revert() {
+	t.obj.Object["spec"] = runtime.DeepCopyJSONValue(t.original.Object["spec"])
+	t.dsts = nil
+}
+
+func (t *unstructuredTarget) namespace() string {
+	return t.obj.GetNamespace()
+}
+
+// destinations returns no destinations, the destinations of unstructured resources aren't Gloo Edge weighted
+// destinations
+func (t *unstructuredTarget) destinations() []destinationPair {
+	return nil
+}
+
+// syncRoutes does nothing, the plugin doesn't manage routes of unstructured resources
+func (t *unstructuredTarget) syncRoutes(func(routes []*gwv1.Route) []*gwv1.Route) {}
+
+func (t *unstructuredTarget) changed() bool {
+	return !equality.Semantic.DeepEqual(t.original.Object["spec"], t.obj.Object["spec"])
+}
+
+// setWeight sets weights of stable and canary destinations, canary destinations are cloned from stable ones
+// when missing
+func (t *unstructuredTarget) setWeight(desiredWeight int32) error {
+	for i := range t.dsts {
+		pair := &t.dsts[i]
+		if pair.Canary == nil {
+			if err := t.kind.addCanaryDestination(pair); err != nil {
+				return err
+			}
+		}
+		pair.Stable["weight"] = int64(100 - desiredWeight)
+		pair.Canary["weight"] = int64(desiredWeight)
+	}
+	return nil
+}
+
+func (t *unstructuredTarget) hasWeight(desiredWeight int32) bool {
+	for _, pair := range t.dsts {
+		if pair.Canary == nil {
+			if desiredWeight != 0 {
+				return false
+			}
+			continue
+		}
+		if getUnstructuredWeight(pair.Stable) != int64(100-desiredWeight) ||
+			getUnstructuredWeight(pair.Canary) != int64(desiredWeight) {
+			return false
+		}
+	}
+	return true
+}
+
+// getUnstructuredWeight returns the weight of a destination, numbers may be decoded as integers or floats
+func getUnstructuredWeight(dst map[string]interface{}) int64 {
+	switch weight := dst["weight"].(type) {
+	case int64:
+		return weight
+	case int32:
+		return int64(weight)
+	case int:
+		return int64(weight)
+	case float64:
+		return int64(weight)
+	default:
+		return -1
+	}
+}
+
+// getUnstructuredDestinations returns stable and canary destinations of selected routes of the resource
+func getUnstructuredDestinations(
+	kind *unstructuredRouteKind,
+	obj *unstructured.Unstructured,
+	rollout *v1alpha1.Rollout,
+	pluginConfig *GlooEdgeTrafficRouting) ([]unstructuredDestinationPair, error) {
+
+	routesField, found, err := unstructured.NestedFieldNoCopy(obj.Object, kind.RoutesPath...)
+	if err != nil || !found {
+		return nil, err
+	}
+	routes, ok := routesField.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s/%s has invalid %s field", kind.Name, obj.GetNamespace(), obj.GetName(),
+			strings.Join(kind.RoutesPath, "."))
+	}
+
+	var ret []unstructuredDestinationPair
+	for _, mapping := range getUpstreamMappings(rollout, pluginConfig) {
+		if len(routes) > 1 && len(mapping.Routes) == 0 {
+			return nil, fmt.Errorf("%s %s/%s has multiple routes but canary config doesn't specify which routes to use",
+				kind.Name, obj.GetNamespace(), obj.GetName())
+		}
+
+		for _, route := range routes {
+			routeObj, ok := route.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(routeObj, "name")
+			if len(mapping.Routes) > 0 && !slices.Contains(mapping.Routes, name) {
+				continue
+			}
+
+			dsts, found, err := unstructured.NestedFieldNoCopy(routeObj, kind.DestinationsPath...)
+			if err != nil {
+				return nil, err
+			}
+			dstList, ok := dsts.([]interface{})
+			if !found || !ok {
+				continue
+			}
+
+			pair := kind.findDestinations(dstList, mapping)
+			if pair.Stable == nil {
+				continue
+			}
+			pair.Route = routeObj
+			pair.Mapping = mapping
+			ret = append(ret, pair)
+		}
+	}
+
+	return ret, nil
+}
+
+// findDestinations returns stable and canary destinations of a route. Destinations are matched by the name
+// of the referenced Service, canary destinations are also matched by namespace if it's set.
+func (k *unstructuredRouteKind) findDestinations(dsts []interface{}, mapping *UpstreamMapping) (ret unstructuredDestinationPair) {
+	for _, dst := range dsts {
+		dstObj, ok := dst.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(dstObj, append(k.RefPath, "name")...)
+		namespace, _, _ := unstructured.NestedString(dstObj, append(k.RefPath, "namespace")...)
+		if name == "" {
+			continue
+		}
+
+		if strings.EqualFold(mapping.Canary, name) &&
+			(mapping.CanaryNamespace == "" || mapping.CanaryNamespace == namespace) {
+			ret.Canary = dstObj
+		} else if strings.EqualFold(mapping.Stable, name) {
+			ret.Stable = dstObj
+		}
+	}
+
+	return ret
+}
+
+// addCanaryDestination adds a canary destination cloned from the stable one to the route of the pair
+func (k *unstructuredRouteKind) addCanaryDestination(pair *unstructuredDestinationPair) error {
+	parent := pair.Route
+	for _, field := range k.DestinationsPath[:len(k.DestinationsPath)-1] {
+		var ok bool
+		if parent, ok = parent[field].(map[string]interface{}); !ok {
+			return fmt.Errorf("%s route has invalid %s field", k.Name, strings.Join(k.DestinationsPath, "."))
+		}
+	}
+	last := k.DestinationsPath[len(k.DestinationsPath)-1]
+	dsts, ok := parent[last].([]interface{})
+	if !ok {
+		return fmt.Errorf("%s route has invalid %s field", k.Name, strings.Join(k.DestinationsPath, "."))
+	}
+
+	pair.Canary = k.newCanaryDestination(pair.Stable, pair.Mapping)
+	parent[last] = append(dsts, pair.Canary)
+	return nil
+}
+
+func (k *unstructuredRouteKind) newCanaryDestination(stable map[string]interface{}, mapping *UpstreamMapping) map[string]interface{} {
+	ret := runtime.DeepCopyJSON(stable)
+	_ = unstructured.SetNestedField(ret, mapping.Canary, append(k.RefPath, "name")...)
+	if mapping.CanaryNamespace != "" {
+		_ = unstructured.SetNestedField(ret, mapping.CanaryNamespace, append(k.RefPath, "namespace")...)
+	}
+	return ret
+}
+
+// getUnstructured returns resources of the kind selected by name or labels
+func (r *RpcPlugin) getUnstructured(
+	ctx context.Context,
+	kind *unstructuredRouteKind,
+	selector *DumbObjectSelector,
+	rollout *v1alpha1.Rollout) ([]*unstructured.Unstructured, error) {
+
+	namespace := selector.Namespace
+
+	if namespace == "" {
+		r.LogCtx.Debugf("defaulting %s selector namespace to Rollout namespace %s for rollout %s", kind.Name, rollout.Namespace, rollout.Name)
+		namespace = rollout.Namespace
+	}
+
+	if selector.Name == "" && len(selector.Labels) == 0 {
+		return nil, fmt.Errorf("name or labels field must be set in %s selector", kind.Name)
+	}
+
+	if selector.Name != "" {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(kind.GVK)
+		err := r.Client.Unstructured().Get(ctx, client.ObjectKey{Namespace: namespace, Name: selector.Name}, obj)
+		if err != nil {
+			return nil, err
+		}
+		return []*unstructured.Unstructured{obj}, nil
+	}
+
+	objs := &unstructured.UnstructuredList{}
+	objs.SetGroupVersionKind(kind.GVK.GroupVersion().WithKind(kind.GVK.Kind + "List"))
+	err := r.Client.Unstructured().List(ctx, objs, client.MatchingLabels(selector.Labels), client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*unstructured.Unstructured, len(objs.Items))
+	for i := range objs.Items {
+		ret[i] = &objs.Items[i]
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,231 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ensureCanaryUpstreams creates canary Upstreams that are referenced by canary destinations but don't exist
+// (e.g. when discovery hasn't created them yet). A missing canary Upstream is cloned from the stable one and
+// pointed at the canary Service.
+func (r *RpcPlugin) ensureCanaryUpstreams(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	defaultNamespace string,
+	destinations []destinationPair) error {
+
+	checked := map[client.ObjectKey]bool{}
+	for _, dst := range destinations {
+		canaryKey := upstreamKey(dst.Canary, defaultNamespace)
+		if checked[canaryKey] {
+			continue
+		}
+		checked[canaryKey] = true
+
+		_, err := r.Client.Upstreams().GetUpstream(ctx, canaryKey)
+		if err == nil {
+			continue
+		}
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+
+		stableKey := upstreamKey(dst.Stable, defaultNamespace)
+		stable, err := r.Client.Upstreams().GetUpstream(ctx, stableKey)
+		if err != nil {
+			return fmt.Errorf("couldn't get stable Upstream %s to create canary Upstream %s from: %w", stableKey, canaryKey, err)
+		}
+
+		canary, err := newCanaryUpstream(stable, canaryKey, getMappedCanaryServiceName(dst.Mapping, rollout), rollout)
+		if err != nil {
+			return err
+		}
+
+		r.LogCtx.Infof("creating canary Upstream %s for rollout %s/%s", canaryKey, rollout.Namespace, rollout.Name)
+		if err = r.Client.Upstreams().CreateUpstream(ctx, canary); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyUpstreamsAccepted checks that the Upstreams that will be receiving traffic have been accepted by Gloo.
+// The canary Upstream isn't checked when it's not getting any traffic (and the same goes for the stable one),
+// so that traffic can always be shifted away from a broken Upstream.
+func (r *RpcPlugin) verifyUpstreamsAccepted(
+	ctx context.Context,
+	desiredWeight int32,
+	defaultNamespace string,
+	destinations []destinationPair) error {
+
+	checked := map[client.ObjectKey]bool{}
+	for _, dst := range destinations {
+		if desiredWeight < 100 {
+			if err := r.verifyUpstreamAccepted(ctx, "stable", upstreamKey(dst.Stable, defaultNamespace), checked); err != nil {
+				return err
+			}
+		}
+		if desiredWeight > 0 {
+			if err := r.verifyUpstreamAccepted(ctx, "canary", upstreamKey(dst.Canary, defaultNamespace), checked); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *RpcPlugin) verifyUpstreamAccepted(ctx context.Context, kind string, key client.ObjectKey, checked map[client.ObjectKey]bool) error {
+	if checked[key] {
+		return nil
+	}
+	checked[key] = true
+
+	us, err := r.Client.Upstreams().GetUpstream(ctx, key)
+	if k8serrors.IsNotFound(err) {
+		return fmt.Errorf("%s Upstream %s doesn't exist", kind, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	if us.Status.GetState() != v1.UpstreamStatus_Accepted {
+		if us.Status.GetReason() != "" {
+			return fmt.Errorf("%s Upstream %s is not Accepted (state: %s, reason: %s)",
+				kind, key, us.Status.GetState(), us.Status.GetReason())
+		}
+		return fmt.Errorf("%s Upstream %s is not Accepted (state: %s)", kind, key, us.Status.GetState())
+	}
+
+	return nil
+}
+
+// removeManagedCanaryDestinations removes canary destinations that point to Upstreams created by the plugin
+// for this rollout; their weight is given back to stable destinations. Returns the keys of the Upstreams that
+// are no longer referenced by the destinations and can be deleted.
+func (r *RpcPlugin) removeManagedCanaryDestinations(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	defaultNamespace string,
+	destinations []destinationPair) ([]client.ObjectKey, error) {
+
+	// canary destinations that hold all traffic of a route are never removed, e.g. when ping and pong services
+	// are switched in a different order than expected
+	inUse := map[client.ObjectKey]bool{}
+	for _, dst := range destinations {
+		if dst.Canary != nil && dst.Canary.GetWeight().GetValue() > 0 && dst.Stable.GetWeight().GetValue() == 0 {
+			inUse[upstreamKey(dst.Canary, defaultNamespace)] = true
+		}
+	}
+
+	managed := map[client.ObjectKey]bool{}
+	var ret []client.ObjectKey
+	for _, dst := range destinations {
+		if dst.Canary == nil {
+			continue
+		}
+
+		canaryKey := upstreamKey(dst.Canary, defaultNamespace)
+		if inUse[canaryKey] {
+			r.LogCtx.Warnf("canary Upstream %s holds all traffic of a route, leaving it in place", canaryKey)
+			continue
+		}
+		isManaged, checked := managed[canaryKey]
+		if !checked {
+			us, err := r.Client.Upstreams().GetUpstream(ctx, canaryKey)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				return nil, err
+			}
+			isManaged = err == nil && isManagedByRollout(us, rollout)
+			managed[canaryKey] = isManaged
+			if isManaged {
+				ret = append(ret, canaryKey)
+			}
+		}
+		if !isManaged {
+			continue
+		}
+
+		dst.Stable.Weight = wrapperspb.UInt32(dst.Stable.GetWeight().GetValue() + dst.Canary.GetWeight().GetValue())
+		multi := dst.DestinationsParent.GetMulti()
+		remaining := make([]*v1.WeightedDestination, 0, len(multi.GetDestinations()))
+		for _, d := range multi.GetDestinations() {
+			if d != dst.Canary {
+				remaining = append(remaining, d)
+			}
+		}
+		multi.Destinations = remaining
+	}
+
+	return ret, nil
+}
+
+func (r *RpcPlugin) deleteManagedUpstreams(ctx context.Context, keys []client.ObjectKey) error {
+	for _, key := range keys {
+		r.LogCtx.Infof("deleting canary Upstream %s", key)
+		if err := r.Client.Upstreams().DeleteUpstream(ctx, key); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCanaryUpstream(stable *v1.Upstream, key client.ObjectKey, canaryService string, rollout *v1alpha1.Rollout) (*v1.Upstream, error) {
+	if stable.Spec.GetKube() == nil {
+		return nil, fmt.Errorf("stable Upstream %s/%s is not a kube Upstream, can't create canary Upstream %s from it",
+			stable.GetNamespace(), stable.GetName(), key)
+	}
+
+	ret := &v1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        key.Name,
+			Namespace:   key.Namespace,
+			Labels:      map[string]string{ManagedByLabel: ManagedByValue},
+			Annotations: map[string]string{RolloutAnnotation: rolloutKey(rollout)},
+		},
+	}
+	stable.Spec.DeepCopyInto(&ret.Spec)
+	ret.Spec.DiscoveryMetadata = nil
+	ret.Spec.GetKube().ServiceName = canaryService
+	// the selector of the stable Upstream likely selects stable pods only, rely on the canary Service instead
+	ret.Spec.GetKube().Selector = nil
+
+	return ret, nil
+}
+
+// getMappedCanaryServiceName returns the name of the Service a missing canary Upstream of the mapping is created
+// for. Names of Upstreams in explicit mappings usually differ from the names of their Services, so the canary
+// Service of the rollout is used unless the mapping sets one.
+func getMappedCanaryServiceName(mapping *UpstreamMapping, rollout *v1alpha1.Rollout) string {
+	if mapping != nil && mapping.CanaryService != "" {
+		return mapping.CanaryService
+	}
+	return getCanaryServiceName(rollout)
+}
+
+func isManagedByRollout(obj metav1.Object, rollout *v1alpha1.Rollout) bool {
+	return obj.GetLabels()[ManagedByLabel] == ManagedByValue &&
+		obj.GetAnnotations()[RolloutAnnotation] == rolloutKey(rollout)
+}
+
+func rolloutKey(rollout *v1alpha1.Rollout) string {
+	return fmt.Sprintf("%s/%s", rollout.Namespace, rollout.Name)
+}
+
+// upstreamKey returns the key of the Upstream the destination points to. Upstream references without
+// a namespace are resolved in the namespace of the resource containing the destination.
+func upstreamKey(dst *v1.WeightedDestination, defaultNamespace string) client.ObjectKey {
+	ns := dst.GetDestination().GetUpstream().GetNamespace()
+	if ns == "" {
+		ns = defaultNamespace
+	}
+	return client.ObjectKey{Namespace: ns, Name: dst.GetDestination().GetUpstream().GetName()}
+}
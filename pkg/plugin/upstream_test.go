@@ -0,0 +1,373 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
+	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
+	"github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/options/kubernetes"
+	"github.com/solo-io/solo-kit/pkg/api/v1/resources/core"
+)
+
+type UpstreamSuite struct {
+	suite.Suite
+	plugin     *RpcPlugin
+	ctrl       *gomock.Controller
+	ctx        context.Context
+	gwclient   *gloov1.MockClientset
+	glooclient *gloomocks.MockClientset
+	usclient   *gloomocks.MockUpstreamClient
+	loggerHook *test.Hook
+}
+
+func (s *UpstreamSuite) SetupTest() {
+	s.ctx = context.TODO()
+	s.ctrl = gomock.NewController(s.T())
+	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	s.usclient = gloomocks.NewMockUpstreamClient(s.ctrl)
+	var testLogger *logrus.Logger
+	testLogger, s.loggerHook = test.NewNullLogger()
+	s.plugin = &RpcPlugin{Client: gloo.NewGlooV1ClientSetFromClientsets(s.gwclient, s.glooclient), LogCtx: testLogger.WithContext(s.ctx)}
+}
+
+func TestUpstreamSuite(t *testing.T) {
+	suite.Run(t, new(UpstreamSuite))
+}
+
+func newUpstreamDestination(name, namespace string, weight uint32) *v1.WeightedDestination {
+	return &v1.WeightedDestination{
+		Destination: &v1.Destination{
+			DestinationType: &v1.Destination_Upstream{
+				Upstream: &core.ResourceRef{Name: name, Namespace: namespace},
+			},
+		},
+		Weight: wrapperspb.UInt32(weight),
+	}
+}
+
+func newTestRollout(stableSvc, canarySvc string) *v1alpha1.Rollout {
+	return &v1alpha1.Rollout{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "rollout-ns", Name: "rollout"},
+		Spec: v1alpha1.RolloutSpec{
+			Strategy: v1alpha1.RolloutStrategy{
+				Canary: &v1alpha1.CanaryStrategy{
+					CanaryService: canarySvc,
+					StableService: stableSvc,
+				},
+			},
+		},
+	}
+}
+
+func (s *UpstreamSuite) Test_ensureCanaryUpstreams_CreatesMissingUpstream() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	stable := &v1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "gloo-system", Name: "stablesvc"},
+		Spec: v1.UpstreamSpec{
+			DiscoveryMetadata: &v1.DiscoveryMetadata{Labels: map[string]string{"app": "echo"}},
+			UpstreamType: &v1.UpstreamSpec_Kube{
+				Kube: &kubernetes.UpstreamSpec{
+					ServiceName:      "stablesvc",
+					ServiceNamespace: "echo",
+					ServicePort:      8080,
+					Selector:         map[string]string{"version": "stable"},
+				},
+			},
+		},
+	}
+	expected := &v1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "gloo-system",
+			Name:        "canarysvc",
+			Labels:      map[string]string{ManagedByLabel: ManagedByValue},
+			Annotations: map[string]string{RolloutAnnotation: "rollout-ns/rollout"},
+		},
+		Spec: v1.UpstreamSpec{
+			UpstreamType: &v1.UpstreamSpec_Kube{
+				Kube: &kubernetes.UpstreamSpec{
+					ServiceName:      "canarysvc",
+					ServiceNamespace: "echo",
+					ServicePort:      8080,
+				},
+			},
+		},
+	}
+
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "canarysvc"})).Times(1).
+		Return(nil, k8serrors.NewNotFound(v1.Resource("upstreams"), "canarysvc"))
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "stablesvc"})).Times(1).Return(stable, nil)
+	s.usclient.EXPECT().CreateUpstream(gomock.Any(), gomock.Eq(expected)).Times(1)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(3)
+
+	err := s.plugin.ensureCanaryUpstreams(s.ctx, rollout, "gloo-system", []destinationPair{
+		{
+			Stable:  newUpstreamDestination("stablesvc", "", 100),
+			Canary:  newUpstreamDestination("canarysvc", "", 0),
+			Mapping: &UpstreamMapping{Stable: "stablesvc", Canary: "canarysvc"},
+		},
+		{
+			Stable:  newUpstreamDestination("stablesvc", "gloo-system", 100),
+			Canary:  newUpstreamDestination("canarysvc", "gloo-system", 0),
+			Mapping: &UpstreamMapping{Stable: "stablesvc", Canary: "canarysvc"},
+		},
+	})
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *UpstreamSuite) Test_ensureCanaryUpstreams_UsesCanaryServiceOfExplicitMapping() {
+	type testCase struct {
+		mapping             *UpstreamMapping
+		expectedServiceName string
+	}
+	for name, tc := range map[string]testCase{
+		"canary service of the rollout": {
+			mapping:             &UpstreamMapping{Stable: "echo-http-v1", Canary: "echo-http-v2"},
+			expectedServiceName: "canarysvc",
+		},
+		"canary service of the mapping": {
+			mapping:             &UpstreamMapping{Stable: "echo-http-v1", Canary: "echo-http-v2", CanaryService: "echo-v2"},
+			expectedServiceName: "echo-v2",
+		},
+	} {
+		s.Run(name, func() {
+			s.SetupTest()
+			stable := &v1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "gloo-system", Name: "echo-http-v1"},
+				Spec: v1.UpstreamSpec{
+					UpstreamType: &v1.UpstreamSpec_Kube{
+						Kube: &kubernetes.UpstreamSpec{ServiceName: "stablesvc", ServiceNamespace: "echo", ServicePort: 8080},
+					},
+				},
+			}
+
+			var created *v1.Upstream
+			s.usclient.EXPECT().GetUpstream(gomock.Any(),
+				gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "echo-http-v2"})).Times(1).
+				Return(nil, k8serrors.NewNotFound(v1.Resource("upstreams"), "echo-http-v2"))
+			s.usclient.EXPECT().GetUpstream(gomock.Any(),
+				gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "echo-http-v1"})).Times(1).Return(stable, nil)
+			s.usclient.EXPECT().CreateUpstream(gomock.Any(), gomock.Any()).Times(1).DoAndReturn(
+				func(_ context.Context, us *v1.Upstream, _ ...client.CreateOption) error {
+					created = us
+					return nil
+				})
+			s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(3)
+
+			err := s.plugin.ensureCanaryUpstreams(s.ctx, newTestRollout("stablesvc", "canarysvc"), "gloo-system", []destinationPair{
+				{
+					Stable:  newUpstreamDestination("echo-http-v1", "", 100),
+					Canary:  newUpstreamDestination("echo-http-v2", "", 0),
+					Mapping: tc.mapping,
+				},
+			})
+
+			assert.NoError(s.T(), err)
+			assert.Equal(s.T(), "echo-http-v2", created.GetName())
+			assert.Equal(s.T(), tc.expectedServiceName, created.Spec.GetKube().GetServiceName())
+		})
+	}
+}
+
+func (s *UpstreamSuite) Test_ensureCanaryUpstreams_ReturnsErrorWhenStableIsNotKube() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "canarysvc"})).Times(1).
+		Return(nil, k8serrors.NewNotFound(v1.Resource("upstreams"), "canarysvc"))
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "stablesvc"})).Times(1).
+		Return(&v1.Upstream{ObjectMeta: metav1.ObjectMeta{Namespace: "gloo-system", Name: "stablesvc"}}, nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(2)
+
+	err := s.plugin.ensureCanaryUpstreams(s.ctx, rollout, "gloo-system", []destinationPair{
+		{
+			Stable:  newUpstreamDestination("stablesvc", "", 100),
+			Canary:  newUpstreamDestination("canarysvc", "", 0),
+			Mapping: &UpstreamMapping{Stable: "stablesvc", Canary: "canarysvc"},
+		},
+	})
+
+	assert.Error(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "is not a kube Upstream")
+}
+
+func (s *UpstreamSuite) Test_ensureCanaryUpstreams_ReturnsErrorWhenGetFails() {
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Any()).Times(1).Return(nil, fmt.Errorf("boom"))
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(1)
+
+	err := s.plugin.ensureCanaryUpstreams(s.ctx, newTestRollout("stablesvc", "canarysvc"), "gloo-system", []destinationPair{
+		{
+			Stable:  newUpstreamDestination("stablesvc", "", 100),
+			Canary:  newUpstreamDestination("canarysvc", "", 0),
+			Mapping: &UpstreamMapping{Stable: "stablesvc", Canary: "canarysvc"},
+		},
+	})
+
+	assert.EqualError(s.T(), err, "boom")
+}
+
+func acceptedUpstream() *v1.Upstream {
+	return &v1.Upstream{Status: v1.UpstreamStatus{State: v1.UpstreamStatus_Accepted}}
+}
+
+func (s *UpstreamSuite) Test_verifyUpstreamsAccepted() {
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "stablesvc"})).Times(1).Return(acceptedUpstream(), nil)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "canarysvc"})).Times(1).Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(2)
+
+	err := s.plugin.verifyUpstreamsAccepted(s.ctx, 10, "gloo-system", []destinationPair{
+		{
+			Stable: newUpstreamDestination("stablesvc", "", 100),
+			Canary: newUpstreamDestination("canarysvc", "", 0),
+		},
+		{
+			Stable: newUpstreamDestination("stablesvc", "gloo-system", 100),
+			Canary: newUpstreamDestination("canarysvc", "gloo-system", 0),
+		},
+	})
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *UpstreamSuite) Test_verifyUpstreamsAccepted_ReturnsErrorWhenCanaryIsNotAccepted() {
+	type testCase struct {
+		description   string
+		upstream      *v1.Upstream
+		err           error
+		expectedError string
+	}
+
+	for _, test := range []testCase{
+		{
+			description: "rejected",
+			upstream: &v1.Upstream{Status: v1.UpstreamStatus{
+				State: v1.UpstreamStatus_Rejected, Reason: "invalid port"}},
+			expectedError: "canary Upstream gloo-system/canarysvc is not Accepted (state: Rejected, reason: invalid port)",
+		},
+		{
+			description:   "pending",
+			upstream:      &v1.Upstream{},
+			expectedError: "canary Upstream gloo-system/canarysvc is not Accepted (state: Pending)",
+		},
+		{
+			description:   "missing",
+			err:           k8serrors.NewNotFound(v1.Resource("upstreams"), "canarysvc"),
+			expectedError: "canary Upstream gloo-system/canarysvc doesn't exist",
+		},
+	} {
+		s.T().Run(test.description, func(t *testing.T) {
+			s.usclient.EXPECT().GetUpstream(gomock.Any(),
+				gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "stablesvc"})).Times(1).Return(acceptedUpstream(), nil)
+			s.usclient.EXPECT().GetUpstream(gomock.Any(),
+				gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "canarysvc"})).Times(1).Return(test.upstream, test.err)
+			s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(2)
+
+			err := s.plugin.verifyUpstreamsAccepted(s.ctx, 10, "gloo-system", []destinationPair{
+				{
+					Stable: newUpstreamDestination("stablesvc", "", 90),
+					Canary: newUpstreamDestination("canarysvc", "", 10),
+				},
+			})
+
+			assert.EqualError(s.T(), err, test.expectedError, test.description)
+		})
+	}
+}
+
+// traffic can always be shifted away from a broken canary
+func (s *UpstreamSuite) Test_verifyUpstreamsAccepted_SkipsCanaryWithZeroWeight() {
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "stablesvc"})).Times(1).Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(1)
+
+	err := s.plugin.verifyUpstreamsAccepted(s.ctx, 0, "gloo-system", []destinationPair{
+		{
+			Stable: newUpstreamDestination("stablesvc", "", 90),
+			Canary: newUpstreamDestination("canarysvc", "", 10),
+		},
+	})
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *UpstreamSuite) Test_removeManagedCanaryDestinations() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+
+	managedStable := newUpstreamDestination("stablesvc", "", 90)
+	managedCanary := newUpstreamDestination("canarysvc", "", 10)
+	managedParent := &v1.RouteAction{
+		Destination: &v1.RouteAction_Multi{
+			Multi: &v1.MultiDestination{Destinations: []*v1.WeightedDestination{managedStable, managedCanary}},
+		},
+	}
+	userStable := newUpstreamDestination("stablesvc", "", 100)
+	userCanary := newUpstreamDestination("canarysvc", "user-ns", 0)
+	userParent := &v1.RouteAction{
+		Destination: &v1.RouteAction_Multi{
+			Multi: &v1.MultiDestination{Destinations: []*v1.WeightedDestination{userStable, userCanary}},
+		},
+	}
+
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "gloo-system", Name: "canarysvc"})).Times(1).
+		Return(&v1.Upstream{ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{ManagedByLabel: ManagedByValue},
+			Annotations: map[string]string{RolloutAnnotation: "rollout-ns/rollout"},
+		}}, nil)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "user-ns", Name: "canarysvc"})).Times(1).
+		Return(&v1.Upstream{}, nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(2)
+
+	unused, err := s.plugin.removeManagedCanaryDestinations(s.ctx, rollout, "gloo-system", []destinationPair{
+		{DestinationsParent: managedParent, Stable: managedStable, Canary: managedCanary},
+		{DestinationsParent: userParent, Stable: userStable, Canary: userCanary},
+	})
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []client.ObjectKey{{Namespace: "gloo-system", Name: "canarysvc"}}, unused)
+	assert.Equal(s.T(), []*v1.WeightedDestination{managedStable}, managedParent.GetMulti().GetDestinations())
+	assert.Equal(s.T(), uint32(100), managedStable.GetWeight().GetValue())
+	assert.Equal(s.T(), []*v1.WeightedDestination{userStable, userCanary}, userParent.GetMulti().GetDestinations())
+}
+
+func (s *UpstreamSuite) Test_removeManagedCanaryDestinations_KeepsCanaryHoldingAllTraffic() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	stable := newUpstreamDestination("stablesvc", "", 0)
+	canary := newUpstreamDestination("canarysvc", "", 100)
+	parent := &v1.RouteAction{
+		Destination: &v1.RouteAction_Multi{
+			Multi: &v1.MultiDestination{Destinations: []*v1.WeightedDestination{stable, canary}},
+		},
+	}
+
+	unused, err := s.plugin.removeManagedCanaryDestinations(s.ctx, rollout, "gloo-system", []destinationPair{
+		{DestinationsParent: parent, Stable: stable, Canary: canary},
+	})
+
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), unused)
+	assert.Equal(s.T(), []*v1.WeightedDestination{stable, canary}, parent.GetMulti().GetDestinations())
+	assert.Equal(s.T(), uint32(100), canary.GetWeight().GetValue())
+}
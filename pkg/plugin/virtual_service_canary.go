@@ -6,43 +6,110 @@ import (
 
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
-	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (r *RpcPlugin) handleCanaryUsingVirtualService(
-	ctx context.Context,
-	rollout *v1alpha1.Rollout,
-	desiredWeight int32,
-	pluginConfig *GlooEdgeTrafficRouting) error {
+type virtualServiceBackend struct {
+	r            *RpcPlugin
+	pluginConfig *GlooEdgeTrafficRouting
+}
+
+type virtualServiceTarget struct {
+	vs       *gwv1.VirtualService
+	original *gwv1.VirtualService
+	dsts     []destinationPair
+}
 
-	vs, err := r.getVirtualService(ctx, rollout, pluginConfig)
+func (b *virtualServiceBackend) discover(ctx context.Context, rollout *v1alpha1.Rollout) ([]routingTarget, error) {
+	vs, err := b.r.getVirtualService(ctx, rollout, b.pluginConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	originalVs := &gwv1.VirtualService{}
-	vs.DeepCopyInto(originalVs)
+	return b.targetsFrom(rollout, vs)
+}
+
+func (b *virtualServiceBackend) discoverFrom(rollout *v1alpha1.Rollout, objs []client.Object) ([]routingTarget, error) {
+	return b.targetsFrom(rollout, objs[0].(*gwv1.VirtualService))
+}
+
+func (b *virtualServiceBackend) targetsFrom(rollout *v1alpha1.Rollout, vs *gwv1.VirtualService) ([]routingTarget, error) {
+	original := &gwv1.VirtualService{}
+	vs.DeepCopyInto(original)
 
-	allDestinations, err := r.getDestinationsInVirtualService(rollout, pluginConfig, vs)
+	dsts, err := b.r.getDestinationsInVirtualService(rollout, b.pluginConfig, vs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	r.maybeConvertSingleToMulti([]routeTableWithDestinations{{Destinations: allDestinations}})
-	r.maybeCreateCanaryDestinations(
-		[]routeTableWithDestinations{{Destinations: allDestinations}}, getCanaryServiceName(rollout))
+	return []routingTarget{&virtualServiceTarget{vs: vs, original: original, dsts: dsts}}, nil
+}
 
-	for _, dst := range allDestinations {
-		dst.Stable.Weight = &wrapperspb.UInt32Value{Value: uint32(100 - desiredWeight)}
-		dst.Canary.Weight = &wrapperspb.UInt32Value{Value: uint32(desiredWeight)}
-	}
+func (b *virtualServiceBackend) patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return b.r.Client.VirtualServices().PatchVirtualService(ctx, obj.(*gwv1.VirtualService), patch, opts...)
+}
 
-	if err = r.Client.VirtualServices().PatchVirtualService(ctx, vs, client.MergeFrom(originalVs)); err != nil {
-		return err
+func (b *virtualServiceBackend) getLive(ctx context.Context, key client.ObjectKey) (client.Object, error) {
+	if b.r.Client.CachedVirtualServices() == nil {
+		return nil, nil
+	}
+	vs, err := b.r.Client.VirtualServices().GetVirtualService(ctx, key)
+	if err != nil {
+		return nil, err
 	}
+	return vs, nil
+}
+
+func (b *virtualServiceBackend) gvk() schema.GroupVersionKind {
+	return gwv1.VirtualServiceGVK
+}
 
-	return nil
+func (b *virtualServiceBackend) managesUpstreams() bool {
+	return true
+}
+
+func (b *virtualServiceBackend) syncPreview(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	desiredWeight int32,
+	targets []routingTarget) error {
+
+	t := targets[0].(*virtualServiceTarget)
+	return b.r.syncPreviewVirtualService(ctx, rollout, t.vs, destinationsForWeight(t.dsts, desiredWeight), b.pluginConfig)
+}
+
+func (b *virtualServiceBackend) deletePreview(ctx context.Context, rollout *v1alpha1.Rollout, targets []routingTarget) error {
+	t := targets[0].(*virtualServiceTarget)
+	return b.r.deletePreviewVirtualService(ctx, rollout, t.vs, b.pluginConfig)
+}
+
+func (t *virtualServiceTarget) object() client.Object {
+	return t.vs
+}
+
+func (t *virtualServiceTarget) originalObject() client.Object {
+	return t.original
+}
+
+func (t *virtualServiceTarget) revert() {
+	t.original.Spec.DeepCopyInto(&t.vs.Spec)
+}
+
+func (t *virtualServiceTarget) namespace() string {
+	return t.vs.GetNamespace()
+}
+
+func (t *virtualServiceTarget) destinations() []destinationPair {
+	return t.dsts
+}
+
+func (t *virtualServiceTarget) syncRoutes(update func(routes []*gwv1.Route) []*gwv1.Route) {
+	t.vs.Spec.VirtualHost.Routes = update(t.vs.Spec.GetVirtualHost().GetRoutes())
+}
+
+func (t *virtualServiceTarget) changed() bool {
+	return !t.vs.Spec.Equal(&t.original.Spec)
 }
 
 func (r *RpcPlugin) getDestinationsInVirtualService(
@@ -55,19 +122,24 @@ func (r *RpcPlugin) getDestinationsInVirtualService(
 			pluginConfig.VirtualServiceSelector.Namespace, pluginConfig.VirtualServiceSelector.Name)
 	}
 
-	if len(vs.Spec.GetVirtualHost().GetRoutes()) > 1 && len(pluginConfig.Routes) == 0 {
-		return nil, fmt.Errorf("virtual host has multiple routes but canary config doesn't specify which routes to use")
-	}
+	routes := unmanagedRoutes(vs.Spec.GetVirtualHost().GetRoutes())
+	for _, mapping := range getUpstreamMappings(rollout, pluginConfig) {
+		if len(routes) > 1 && len(mapping.Routes) == 0 {
+			return nil, fmt.Errorf("virtual host has multiple routes but canary config doesn't specify which routes to use")
+		}
 
-	ret = r.getDestinationsInRoutes(vs.Spec.GetVirtualHost().GetRoutes(), rollout, pluginConfig)
+		dsts := r.getMappedDestinationsInRoutes(routes, mapping)
 
-	if len(pluginConfig.Routes) > 0 && len(ret) != len(pluginConfig.Routes) {
-		return nil, fmt.Errorf("some/all routes specified in canary rollout configuration do not have stable upstreams")
-	}
+		if len(mapping.Routes) > 0 && len(dsts) != len(mapping.Routes) {
+			return nil, fmt.Errorf("some/all routes specified in canary rollout configuration do not have stable upstreams")
+		}
+
+		if len(dsts) == 0 {
+			return nil, fmt.Errorf("couldn't find stable upstreams in VirtualService %s/%s, with route names in %v",
+				pluginConfig.VirtualServiceSelector.Namespace, pluginConfig.VirtualServiceSelector.Name, mapping.Routes)
+		}
 
-	if len(ret) == 0 {
-		return nil, fmt.Errorf("couldn't find stable upstreams in VirtualService %s/%s, with route names in %v",
-			pluginConfig.VirtualServiceSelector.Namespace, pluginConfig.VirtualServiceSelector.Name, pluginConfig.Routes)
+		ret = append(ret, dsts...)
 	}
 
 	return ret, nil
@@ -85,7 +157,7 @@ func (r *RpcPlugin) getVirtualService(ctx context.Context, rollout *v1alpha1.Rol
 		return nil, fmt.Errorf("must specify the name of the VirtualService")
 	}
 
-	vs, err := r.Client.VirtualServices().GetVirtualService(ctx,
+	vs, err := r.virtualServiceReader().GetVirtualService(ctx,
 		client.ObjectKey{Namespace: vsNamespace, Name: pluginConfig.VirtualServiceSelector.Name})
 	if err != nil {
 		return nil, err
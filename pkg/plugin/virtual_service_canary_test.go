@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooedge/pkg/gloo"
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	"github.com/golang/mock/gomock"
 	"github.com/sirupsen/logrus"
@@ -13,11 +14,15 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/protobuf/types/known/wrapperspb"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	gwv1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1"
 	gloov1 "github.com/solo-io/solo-apis/pkg/api/gateway.solo.io/v1/mocks"
 	v1 "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1"
+	gloomocks "github.com/solo-io/solo-apis/pkg/api/gloo.solo.io/v1/mocks"
 	"github.com/solo-io/solo-kit/pkg/api/v1/resources/core"
 )
 
@@ -27,6 +32,8 @@ type VirtualServiceCanarySuite struct {
 	ctrl       *gomock.Controller
 	ctx        context.Context
 	gwclient   *gloov1.MockClientset
+	glooclient *gloomocks.MockClientset
+	usclient   *gloomocks.MockUpstreamClient
 	vsclient   *gloov1.MockVirtualServiceClient
 	loggerHook *test.Hook
 }
@@ -35,12 +42,14 @@ func (s *VirtualServiceCanarySuite) SetupTest() {
 	s.ctx = context.TODO()
 	s.ctrl = gomock.NewController(s.T())
 	s.gwclient = gloov1.NewMockClientset(s.ctrl)
+	s.glooclient = gloomocks.NewMockClientset(s.ctrl)
+	s.usclient = gloomocks.NewMockUpstreamClient(s.ctrl)
 	s.vsclient = gloov1.NewMockVirtualServiceClient(s.ctrl)
 	var testLogger *logrus.Logger
 	// see https://github.com/mpchadwick/dbanon/blob/v0.6.0/src/provider_test.go#L39-L42
 	// for example of how to use the hook in tests
 	testLogger, s.loggerHook = test.NewNullLogger()
-	s.plugin = &RpcPlugin{Client: s.gwclient, LogCtx: testLogger.WithContext(s.ctx)}
+	s.plugin = &RpcPlugin{Client: gloo.NewGlooV1ClientSetFromClientsets(s.gwclient, s.glooclient), LogCtx: testLogger.WithContext(s.ctx)}
 }
 
 func TestVirtualServiceCanarySuite(t *testing.T) {
@@ -602,6 +611,12 @@ func (s *VirtualServiceCanarySuite) Test_handleCanary_UsingVirtualService() {
 		gomock.Any(),
 		gomock.Eq(&expectedVs),
 		gomock.Any())
+	// used in ensureCanaryUpstreams() and verifyUpstreamsAccepted()
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Name: canarysvc})).Times(2).Return(acceptedUpstream(), nil)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Name: stablesvc})).Times(1).Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(3)
 
 	filterConfig, err := json.Marshal(GlooEdgeTrafficRouting{
 		Routes: []string{route1, route2, route3},
@@ -641,7 +656,7 @@ func (s *VirtualServiceCanarySuite) Test_handleCanary_ReturnErrorIfGetVirtualSer
 	// used in getVS()
 	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(1)
 
-	err := s.plugin.handleCanaryUsingVirtualService(s.ctx,
+	err := s.plugin.setWeight(s.ctx,
 		&v1alpha1.Rollout{
 			Spec: v1alpha1.RolloutSpec{
 				Strategy: v1alpha1.RolloutStrategy{
@@ -673,7 +688,7 @@ func (s *VirtualServiceCanarySuite) Test_handleCanary_ReturnErrorIfGetDestionati
 	// used in getVS() and handleCanary()
 	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(1)
 
-	err := s.plugin.handleCanaryUsingVirtualService(s.ctx,
+	err := s.plugin.setWeight(s.ctx,
 		&v1alpha1.Rollout{
 			Spec: v1alpha1.RolloutSpec{
 				Strategy: v1alpha1.RolloutStrategy{
@@ -694,3 +709,328 @@ func (s *VirtualServiceCanarySuite) Test_handleCanary_ReturnErrorIfGetDestionati
 	assert.Error(s.T(), err)
 	assert.Contains(s.T(), err.Error(), "no virtual host or empty routes in VirtualSevice")
 }
+
+func (s *VirtualServiceCanarySuite) Test_removeManagedRoutesUsingVirtualService() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	stable := newUpstreamDestination("stablesvc", "", 100)
+	vs := &gwv1.VirtualService{
+		Spec: gwv1.VirtualServiceSpec{
+			VirtualHost: &gwv1.VirtualHost{
+				Routes: []*gwv1.Route{
+					{
+						Action: &gwv1.Route_RouteAction{
+							RouteAction: &v1.RouteAction{
+								Destination: &v1.RouteAction_Multi{
+									Multi: &v1.MultiDestination{
+										Destinations: []*v1.WeightedDestination{
+											stable,
+											newUpstreamDestination("canarysvc", "", 0),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	vs.SetNamespace("testns")
+
+	expectedVs := &gwv1.VirtualService{}
+	vs.DeepCopyInto(expectedVs)
+	expectedVs.Spec.GetVirtualHost().GetRoutes()[0].GetRouteAction().GetMulti().Destinations =
+		[]*v1.WeightedDestination{newUpstreamDestination("stablesvc", "", 100)}
+
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "testvs"})).Times(1).Return(vs, nil)
+	s.vsclient.EXPECT().PatchVirtualService(gomock.Any(), gomock.Eq(expectedVs), gomock.Any()).Times(1)
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(2)
+	// used in restoreCanaryUpstreams() and removeManagedCanaryDestinations()
+	s.usclient.EXPECT().GetUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "canarysvc"})).Times(2).
+		Return(&v1.Upstream{ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{ManagedByLabel: ManagedByValue},
+			Annotations: map[string]string{RolloutAnnotation: "rollout-ns/rollout"},
+		}}, nil)
+	s.usclient.EXPECT().DeleteUpstream(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "canarysvc"})).Times(1)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).Times(3)
+
+	err := s.plugin.removeManagedRoutes(s.ctx, rollout, &GlooEdgeTrafficRouting{
+		VirtualServiceSelector: &DumbObjectSelector{Namespace: "testns", Name: "testvs"},
+	})
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *VirtualServiceCanarySuite) Test_getDestinationsInVirtualService_MultipleUpstreamMappings() {
+	vs := &gwv1.VirtualService{
+		Spec: gwv1.VirtualServiceSpec{
+			VirtualHost: &gwv1.VirtualHost{
+				Routes: []*gwv1.Route{
+					{
+						Name: "http",
+						Action: &gwv1.Route_RouteAction{
+							RouteAction: &v1.RouteAction{
+								Destination: &v1.RouteAction_Multi{
+									Multi: &v1.MultiDestination{
+										Destinations: []*v1.WeightedDestination{
+											newUpstreamDestination("http-stable", "", 100),
+										},
+									},
+								},
+							},
+						},
+					},
+					{
+						Name: "grpc",
+						Action: &gwv1.Route_RouteAction{
+							RouteAction: &v1.RouteAction{
+								Destination: &v1.RouteAction_Multi{
+									Multi: &v1.MultiDestination{
+										Destinations: []*v1.WeightedDestination{
+											newUpstreamDestination("grpc-stable", "", 90),
+											newUpstreamDestination("grpc-canary", "", 10),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	pluginConfig := &GlooEdgeTrafficRouting{
+		VirtualServiceSelector: &DumbObjectSelector{Namespace: "testns", Name: "testvs"},
+		Upstreams: []UpstreamMapping{
+			{Stable: "http-stable", Canary: "http-canary", Routes: []string{"http"}},
+			{Stable: "grpc-stable", Canary: "grpc-canary", Routes: []string{"grpc"}},
+		},
+	}
+
+	dsts, err := s.plugin.getDestinationsInVirtualService(newTestRollout("stablesvc", "canarysvc"), pluginConfig, vs)
+
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), dsts, 2)
+	assert.Equal(s.T(), "http-stable", dsts[0].Stable.GetDestination().GetUpstream().GetName())
+	assert.Nil(s.T(), dsts[0].Canary)
+	assert.Equal(s.T(), "http-canary", dsts[0].Mapping.Canary)
+	assert.Equal(s.T(), "grpc-stable", dsts[1].Stable.GetDestination().GetUpstream().GetName())
+	assert.Equal(s.T(), "grpc-canary", dsts[1].Canary.GetDestination().GetUpstream().GetName())
+
+	// each mapping must be found in its routes
+	pluginConfig.Upstreams[1].Routes = []string{"http"}
+	_, err = s.plugin.getDestinationsInVirtualService(newTestRollout("stablesvc", "canarysvc"), pluginConfig, vs)
+	assert.Error(s.T(), err)
+}
+
+func (s *VirtualServiceCanarySuite) Test_verifyWeight_UsingVirtualService() {
+	vs := &gwv1.VirtualService{
+		Spec: gwv1.VirtualServiceSpec{
+			VirtualHost: &gwv1.VirtualHost{
+				Routes: []*gwv1.Route{
+					{
+						Action: &gwv1.Route_RouteAction{
+							RouteAction: &v1.RouteAction{
+								Destination: &v1.RouteAction_Multi{
+									Multi: &v1.MultiDestination{
+										Destinations: []*v1.WeightedDestination{
+											newUpstreamDestination("stablesvc", "", 90),
+											newUpstreamDestination("canarysvc", "", 10),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "testvs"})).Times(2).Return(vs, nil)
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(2)
+	pluginConfig := &GlooEdgeTrafficRouting{
+		VirtualServiceSelector: &DumbObjectSelector{Namespace: "testns", Name: "testvs"},
+	}
+
+	verified, err := s.plugin.verifyWeight(s.ctx, newTestRollout("stablesvc", "canarysvc"), 10, pluginConfig)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), verified)
+
+	verified, err = s.plugin.verifyWeight(s.ctx, newTestRollout("stablesvc", "canarysvc"), 20, pluginConfig)
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), verified)
+}
+
+func newConflictTestVirtualService() *gwv1.VirtualService {
+	vs := &gwv1.VirtualService{
+		Spec: gwv1.VirtualServiceSpec{
+			VirtualHost: &gwv1.VirtualHost{
+				Routes: []*gwv1.Route{
+					{
+						Action: &gwv1.Route_RouteAction{
+							RouteAction: &v1.RouteAction{
+								Destination: &v1.RouteAction_Multi{
+									Multi: &v1.MultiDestination{
+										Destinations: []*v1.WeightedDestination{
+											newUpstreamDestination("stablesvc", "", 100),
+											newUpstreamDestination("canarysvc", "", 0),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	vs.SetNamespace("testns")
+	vs.SetName("testvs")
+	return vs
+}
+
+func newConflictTestRollout() *v1alpha1.Rollout {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	rollout.Spec.Strategy.Canary.TrafficRouting = &v1alpha1.RolloutTrafficRouting{
+		Plugins: map[string]json.RawMessage{
+			PluginName: []byte(`{"virtualService": {"name": "testvs", "namespace": "testns"}}`),
+		},
+	}
+	return rollout
+}
+
+func (s *VirtualServiceCanarySuite) Test_SetWeight_RetriesOnConflict() {
+	vs := newConflictTestVirtualService()
+
+	// the VirtualService is read again after the conflict
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "testvs"})).Times(2).DoAndReturn(
+		func(context.Context, client.ObjectKey) (*gwv1.VirtualService, error) {
+			return vs.DeepCopy(), nil
+		})
+	gomock.InOrder(
+		s.vsclient.EXPECT().PatchVirtualService(gomock.Any(), gomock.Any(), gomock.Any()).Return(newConflict()),
+		s.vsclient.EXPECT().PatchVirtualService(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ context.Context, vs *gwv1.VirtualService, _ client.Patch, _ ...client.PatchOption) error {
+				dsts := vs.Spec.GetVirtualHost().GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()
+				assert.Equal(s.T(), uint32(70), dsts[0].GetWeight().GetValue())
+				assert.Equal(s.T(), uint32(30), dsts[1].GetWeight().GetValue())
+				return nil
+			}),
+	)
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(4)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Any()).AnyTimes().Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).AnyTimes()
+
+	err := s.plugin.SetWeight(newConflictTestRollout(), 30, nil)
+
+	assert.Empty(s.T(), err.ErrorString)
+}
+
+func (s *VirtualServiceCanarySuite) Test_SetWeight_GivesUpAfterConflicts() {
+	withConflictBackoff(s.T(), wait.Backoff{Steps: 3})
+	vs := newConflictTestVirtualService()
+
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(), gomock.Any()).Times(3).DoAndReturn(
+		func(context.Context, client.ObjectKey) (*gwv1.VirtualService, error) {
+			return vs.DeepCopy(), nil
+		})
+	s.vsclient.EXPECT().PatchVirtualService(gomock.Any(), gomock.Any(), gomock.Any()).Times(3).Return(newConflict())
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(6)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Any()).AnyTimes().Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).AnyTimes()
+
+	err := s.plugin.SetWeight(newConflictTestRollout(), 30, nil)
+
+	assert.Contains(s.T(), err.ErrorString, "giving up after 3 conflicts")
+}
+
+func (s *VirtualServiceCanarySuite) Test_setWeight_SkipsPatchWhenWeightsAreUpToDate() {
+	vs := newConflictTestVirtualService()
+	dsts := vs.Spec.GetVirtualHost().GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()
+	dsts[0].Weight = wrapperspb.UInt32(70)
+	dsts[1].Weight = wrapperspb.UInt32(30)
+
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "testvs"})).Times(1).Return(vs, nil)
+	// PatchVirtualService isn't expected
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).Times(1)
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Any()).AnyTimes().Return(acceptedUpstream(), nil)
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).AnyTimes()
+
+	err := s.plugin.setWeight(s.ctx, newTestRollout("stablesvc", "canarysvc"), 30, &GlooEdgeTrafficRouting{
+		VirtualServiceSelector: &DumbObjectSelector{Namespace: "testns", Name: "testvs"},
+	})
+
+	assert.NoError(s.T(), err)
+}
+
+func (s *VirtualServiceCanarySuite) Test_setWeight_DoesNotRecreateCanaryRemovedByRemoveManagedRoutes() {
+	vs := newConflictTestVirtualService()
+	canaryKey := client.ObjectKey{Namespace: "testns", Name: "canarysvc"}
+	canaryUpstream := &v1.Upstream{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "testns",
+		Name:        "canarysvc",
+		Labels:      map[string]string{ManagedByLabel: ManagedByValue},
+		Annotations: map[string]string{RolloutAnnotation: "rollout-ns/rollout"},
+	}}
+
+	s.vsclient.EXPECT().GetVirtualService(gomock.Any(),
+		gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "testvs"})).AnyTimes().DoAndReturn(
+		func(context.Context, client.ObjectKey) (*gwv1.VirtualService, error) {
+			return vs.DeepCopy(), nil
+		})
+	s.vsclient.EXPECT().PatchVirtualService(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).DoAndReturn(
+		func(_ context.Context, patched *gwv1.VirtualService, _ client.Patch, _ ...client.PatchOption) error {
+			vs = patched.DeepCopy()
+			return nil
+		})
+	s.gwclient.EXPECT().VirtualServices().Return(s.vsclient).AnyTimes()
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Eq(canaryKey)).AnyTimes().DoAndReturn(
+		func(context.Context, client.ObjectKey) (*v1.Upstream, error) {
+			if canaryUpstream == nil {
+				return nil, k8serrors.NewNotFound(v1.UpstreamGVK.GroupVersion().WithResource("upstreams").GroupResource(), canaryKey.Name)
+			}
+			return canaryUpstream, nil
+		})
+	s.usclient.EXPECT().GetUpstream(gomock.Any(), gomock.Eq(client.ObjectKey{Namespace: "testns", Name: "stablesvc"})).
+		AnyTimes().Return(acceptedUpstream(), nil)
+	s.usclient.EXPECT().DeleteUpstream(gomock.Any(), gomock.Eq(canaryKey)).Times(1).DoAndReturn(
+		func(context.Context, client.ObjectKey, ...client.DeleteOption) error {
+			canaryUpstream = nil
+			return nil
+		})
+	// CreateUpstream isn't expected
+	s.glooclient.EXPECT().Upstreams().Return(s.usclient).AnyTimes()
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	pluginConfig := &GlooEdgeTrafficRouting{
+		VirtualServiceSelector: &DumbObjectSelector{Namespace: "testns", Name: "testvs"},
+	}
+
+	// Argo Rollouts calls RemoveManagedRoutes and then SetWeight with 0 weight on each reconciliation of a finished rollout
+	for i := 0; i < 2; i++ {
+		assert.NoError(s.T(), s.plugin.removeManagedRoutes(s.ctx, rollout, pluginConfig))
+		assert.NoError(s.T(), s.plugin.setWeight(s.ctx, rollout, 0, pluginConfig))
+	}
+
+	dsts := vs.Spec.GetVirtualHost().GetRoutes()[0].GetRouteAction().GetMulti().GetDestinations()
+	assert.Len(s.T(), dsts, 1)
+	assert.Equal(s.T(), "stablesvc", dsts[0].GetDestination().GetUpstream().GetName())
+	assert.Equal(s.T(), uint32(100), dsts[0].GetWeight().GetValue())
+}
+
+func (s *VirtualServiceCanarySuite) Test_removeManagedRoutes_SkipsRolloutsBeingFullyPromoted() {
+	rollout := newTestRollout("stablesvc", "canarysvc")
+	rollout.Status.PromoteFull = true
+
+	// no API calls are expected
+	err := s.plugin.removeManagedRoutes(s.ctx, rollout, &GlooEdgeTrafficRouting{
+		VirtualServiceSelector: &DumbObjectSelector{Namespace: "testns", Name: "testvs"},
+	})
+
+	assert.NoError(s.T(), err)
+}